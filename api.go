@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultApiLimit = 1000
+	MaxApiLimit     = 10000
+	NdjsonType      = "application/x-ndjson"
+)
+
+type SpotsResponse struct {
+	Spots      []*Payload `json:"spots"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// Accept both Unix seconds and RFC 3339 timestamps
+func parseTimestamp(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return seconds, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("not a Unix or RFC 3339 timestamp: %q", value)
+	}
+	return uint64(timestamp.Unix()), nil
+}
+
+// Check the since and until parameters, which the filter then goes by, and
+// parse the limit, common to the API endpoints
+func parseRange(query url.Values) (limit int, err error) {
+	if _, err = parseTimestamp(query.Get("since")); err != nil {
+		return 0, fmt.Errorf("since: %w", err)
+	}
+	if _, err = parseTimestamp(query.Get("until")); err != nil {
+		return 0, fmt.Errorf("until: %w", err)
+	}
+
+	limit = DefaultApiLimit
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			return 0, fmt.Errorf("limit: not a positive integer: %q", value)
+		}
+		limit = min(limit, MaxApiLimit)
+	}
+
+	return limit, nil
+}
+
+func spotsApiHandler(getConfig func() Config) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		config := getConfig()
+		log.Debug().Str("query", request.URL.RawQuery).Msg("Serving spots over API")
+
+		filter := NewFilter(config, request)
+		if err := filter.Err(); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := request.URL.Query()
+
+		limit, err := parseRange(query)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var cursor PageCursor
+		if value := query.Get("cursor"); value != "" {
+			var ok bool
+			if cursor, ok = parsePageCursor(value); !ok {
+				http.Error(writer, fmt.Sprintf("cursor: not a cursor from next_cursor: %q", value), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Pages are in time order, oldest first unless asked otherwise, with
+		// the cursor pointing at the last spot returned. One spot more than
+		// the limit tells whether there's a next page.
+		var page []*Payload
+		switch query.Get("order") {
+		case "", "oldest":
+			page = findNextPage(filter, cursor, limit+1)
+		case "newest":
+			page = findPage(filter, cursor, limit+1)
+		default:
+			http.Error(writer, fmt.Sprintf("order: expecting oldest or newest, not %q", query.Get("order")), http.StatusBadRequest)
+			return
+		}
+
+		response := SpotsResponse{Spots: page}
+		if len(page) > limit {
+			last := page[limit-1]
+			response.Spots = page[:limit]
+			response.NextCursor = PageCursor{Time: last.Time, Sequence: last.SequenceNumber}.String()
+		}
+
+		if strings.Contains(request.Header.Get("Accept"), NdjsonType) {
+			writer.Header().Set("Content-Type", NdjsonType)
+			if response.NextCursor != "" {
+				writer.Header().Set("X-Next-Cursor", response.NextCursor)
+			}
+			encoder := json.NewEncoder(writer)
+			for _, spot := range response.Spots {
+				if err := encoder.Encode(spot); err != nil {
+					log.Debug().Err(err).Msg("Could not write spot")
+					return
+				}
+			}
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(writer).Encode(response); err != nil {
+			log.Debug().Err(err).Msg("Could not write spots")
+		}
+	}
+}
+
+// The oldest limit spots newer than the cursor, oldest first, the other way
+// around from findPage
+func findNextPage(filter Filter, cursor PageCursor, limit int) []*Payload {
+	if cursor.Time > filter.Since {
+		filter.Since = cursor.Time
+	}
+
+	skipping := cursor.Sequence != 0
+	page := make([]*Payload, 0, limit)
+	for spot := range spotIndex.Oldest(&filter) {
+		if skipping && spot.Time == cursor.Time {
+			if spot.SequenceNumber == cursor.Sequence {
+				skipping = false
+			}
+			continue
+		}
+		skipping = false
+		if len(page) == limit {
+			break
+		}
+		page = append(page, spot)
+	}
+
+	return page
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestSpotsApiHandler(t *testing.T) {
+	previous := spotIndex
+	spotIndex = NewSpotIndex()
+	defer func() { spotIndex = previous }()
+
+	// Seconds apart, the last few out of order and all in the same second
+	for number := range MaxApiLimit + 5 {
+		band := "2m"
+		if number%2 == 1 {
+			band = "70cm"
+		}
+		spotIndex.Insert(&Payload{SequenceNumber: uint64(number + 1), Time: uint64(1000 + min(number, MaxApiLimit)), Band: band})
+	}
+	config := Config{Bands: []string{"2m", "70cm"}}
+	handler := spotsApiHandler(func() Config { return config })
+
+	get := func(query string, accept string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "/api/spots?"+query, nil)
+		if accept != "" {
+			request.Header.Set("Accept", accept)
+		}
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		return recorder
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		accept     string
+		status     int
+		first      uint64
+		count      int
+		nextCursor string
+	}{
+		{"default limit", "", "", http.StatusOK, 1, DefaultApiLimit, "1999-1000"},
+		{"limit capped", "limit=100000", "", http.StatusOK, 1, MaxApiLimit, "10999-10000"},
+		{"cursor", "limit=2&cursor=1001-2", "", http.StatusOK, 3, 2, "1003-4"},
+		{"cursor within a second", "limit=2&cursor=11000-10002", "", http.StatusOK, 10003, 2, "11000-10004"},
+		{"last page", "limit=10&cursor=11000-10002", "", http.StatusOK, 10003, 3, ""},
+		{"filtered", "bands=70cm&limit=2&cursor=1001-2", "", http.StatusOK, 4, 2, "1005-6"},
+		{"newest", "limit=2&order=newest", "", http.StatusOK, 10005, 2, "11000-10004"},
+		{"newest from cursor", "limit=2&order=newest&cursor=1004-5", "", http.StatusOK, 4, 2, "1002-3"},
+		{"ndjson", "limit=2&cursor=1001-2", NdjsonType, http.StatusOK, 3, 2, "1003-4"},
+		{"bad limit", "limit=0", "", http.StatusBadRequest, 0, 0, ""},
+		{"bad cursor", "cursor=1001", "", http.StatusBadRequest, 0, 0, ""},
+		{"bad order", "order=random", "", http.StatusBadRequest, 0, 0, ""},
+		{"bad since", "since=yesterday", "", http.StatusBadRequest, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := get(tt.query, tt.accept)
+			if response.Code != tt.status {
+				t.Fatalf("status = %d, want %d", response.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var spots []*Payload
+			var nextCursor string
+			if tt.accept == NdjsonType {
+				if got := response.Header().Get("Content-Type"); got != NdjsonType {
+					t.Errorf("Content-Type = %q, want %q", got, NdjsonType)
+				}
+				nextCursor = response.Header().Get("X-Next-Cursor")
+				scanner := bufio.NewScanner(response.Body)
+				for scanner.Scan() {
+					var spot Payload
+					if err := json.Unmarshal(scanner.Bytes(), &spot); err != nil {
+						t.Fatalf("line %q: %v", scanner.Text(), err)
+					}
+					spots = append(spots, &spot)
+				}
+			} else {
+				var body SpotsResponse
+				if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				if response.Header().Get("X-Next-Cursor") != "" {
+					t.Errorf("X-Next-Cursor set on a JSON response")
+				}
+				spots, nextCursor = body.Spots, body.NextCursor
+			}
+
+			if len(spots) != tt.count || spots[0].SequenceNumber != tt.first {
+				t.Errorf("got %d spots from %v, want %d from %d", len(spots), sequences(spots[:min(len(spots), 3)]), tt.count, tt.first)
+			}
+			if nextCursor != tt.nextCursor {
+				t.Errorf("next cursor = %q, want %q", nextCursor, tt.nextCursor)
+			}
+		})
+	}
+
+	// Following the cursor goes through every spot exactly once
+	var seen []uint64
+	cursor := ""
+	for range MaxApiLimit {
+		var body SpotsResponse
+		json.NewDecoder(get("bands=2m&limit=999&cursor="+cursor, "").Body).Decode(&body)
+		seen = append(seen, sequences(body.Spots)...)
+		if cursor = body.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if len(seen) != (MaxApiLimit+5+1)/2 || !slices.IsSorted(seen) || seen[0] != 1 {
+		t.Errorf("paging went through %d spots, want all %d once and in order", len(seen), (MaxApiLimit+5+1)/2)
+	}
+}
+
+func TestSpotsApiHandlerSameSecond(t *testing.T) {
+	previous := spotIndex
+	spotIndex = NewSpotIndex()
+	defer func() { spotIndex = previous }()
+
+	// The first page reads the mode's list, and the next ones, with since
+	// moved up to the cursor, the bands' lists
+	for _, spot := range []*Payload{
+		{SequenceNumber: 5, Time: 100, Band: "6m", Mode: "FT8"},
+		{SequenceNumber: 3, Time: 100, Band: "6m", Mode: "FT8"},
+		{SequenceNumber: 4, Time: 100, Band: "70cm", Mode: "FT8"},
+		{SequenceNumber: 9, Time: 100, Band: "2m", Mode: "FT4"},
+	} {
+		spotIndex.Insert(spot)
+	}
+	for number := range 10 {
+		spotIndex.Insert(&Payload{SequenceNumber: uint64(10 + number), Time: 50, Band: "6m", Mode: "FT4"})
+	}
+	config := Config{Bands: []string{"6m", "2m", "70cm"}}
+	handler := spotsApiHandler(func() Config { return config })
+
+	for _, order := range []string{"oldest", "newest"} {
+		t.Run(order, func(t *testing.T) {
+			var seen []uint64
+			cursor := ""
+			for range 10 {
+				recorder := httptest.NewRecorder()
+				handler(recorder, httptest.NewRequest("GET", "/api/spots?bands=6m,70cm&modes=FT8&limit=1&order="+order+"&cursor="+cursor, nil))
+				var body SpotsResponse
+				json.NewDecoder(recorder.Body).Decode(&body)
+				seen = append(seen, sequences(body.Spots)...)
+				if cursor = body.NextCursor; cursor == "" {
+					break
+				}
+			}
+			want := []uint64{3, 4, 5}
+			if order == "newest" {
+				want = []uint64{5, 4, 3}
+			}
+			if !slices.Equal(seen, want) {
+				t.Errorf("paging went through %v, want %v", seen, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+// What to do with a spot when the spotlog isn't keeping up and the queue to it
+// is full: wait for room, make room by dropping the oldest queued spot, or
+// drop the new one
+const (
+	BackpressureBlock      = "block"
+	BackpressureDropOldest = "drop-oldest"
+	BackpressureDropNewest = "drop-newest"
+)
+
+var BackpressurePolicies = []string{BackpressureBlock, BackpressureDropOldest, BackpressureDropNewest}
+
+// Deliver a spot according to the policy, telling whether one was dropped
+func deliver(policy string, spots chan *Payload, spot *Payload) bool {
+	switch policy {
+	case BackpressureDropNewest:
+		select {
+		case spots <- spot:
+			return false
+		default:
+			return true
+		}
+	case BackpressureDropOldest:
+		dropped := false
+		for {
+			select {
+			case spots <- spot:
+				return dropped
+			default:
+			}
+			// The spotlog may have emptied the queue in the meantime
+			select {
+			case <-spots:
+				dropped = true
+			default:
+			}
+		}
+	default:
+		spots <- spot
+		return false
+	}
+}
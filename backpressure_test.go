@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestDeliver(t *testing.T) {
+	tests := []struct {
+		policy      string
+		wantDropped bool
+		wantFirst   uint64
+	}{
+		{BackpressureDropNewest, true, 1},
+		{BackpressureDropOldest, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			spots := make(chan *Payload, 2)
+			for sequence := uint64(1); sequence <= 2; sequence++ {
+				if deliver(tt.policy, spots, &Payload{SequenceNumber: sequence}) {
+					t.Fatalf("deliver() dropped a spot with room in the queue")
+				}
+			}
+			if dropped := deliver(tt.policy, spots, &Payload{SequenceNumber: 3}); dropped != tt.wantDropped {
+				t.Errorf("deliver() = %v, want %v", dropped, tt.wantDropped)
+			}
+			if len(spots) != 2 {
+				t.Errorf("queue has %d spots, want 2", len(spots))
+			}
+			if first := (<-spots).SequenceNumber; first != tt.wantFirst {
+				t.Errorf("first queued spot is %d, want %d", first, tt.wantFirst)
+			}
+		})
+	}
+
+	t.Run(BackpressureBlock, func(t *testing.T) {
+		spots := make(chan *Payload, 1)
+		deliver(BackpressureBlock, spots, &Payload{SequenceNumber: 1})
+		done := make(chan bool)
+		go func() {
+			done <- deliver(BackpressureBlock, spots, &Payload{SequenceNumber: 2})
+		}()
+		if first := (<-spots).SequenceNumber; first != 1 {
+			t.Errorf("first queued spot is %d, want 1", first)
+		}
+		if <-done {
+			t.Errorf("deliver() dropped a spot")
+		}
+		if second := (<-spots).SequenceNumber; second != 2 {
+			t.Errorf("second queued spot is %d, want 2", second)
+		}
+	})
+}
@@ -1,94 +1,706 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"github.com/BurntSushi/toml"
 	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	DefaultBands            = "6m,4m,2m,70cm,23cm"
-	DefaultCountry          = 224 // Finland; see https://www.adif.org/304/ADIF_304.htm#Country_Codes
-	DefaultBroker           = "mqtt.pskreporter.info:1883"
-	DefaultMetricsAddrPort  = ":9108"
-	DefaultSpotlogAddrPort  = ":8071"
-	DefaultSpotlogRetention = time.Duration(time.Hour * 60)
+	DefaultBands             = "6m,4m,2m,70cm,23cm"
+	DefaultCountries         = "224" // Finland; see https://www.adif.org/304/ADIF_304.htm#Country_Codes
+	DefaultBroker            = "mqtt.pskreporter.info:1883"
+	DefaultMetricsAddrPort   = ":9108"
+	DefaultSpotlogAddrPort   = ":8071"
+	DefaultSpotlogRetention  = time.Duration(time.Hour * 60)
+	DefaultSpotlogStore      = ""
+	DefaultActiveStations    = ""
+	DefaultWatchCallsigns    = ""
+	DefaultMqttQos           = 0
+	DefaultMqttCleanSession  = true
+	DefaultMqttKeepalive     = time.Duration(time.Second * 10)
+	DefaultSpotsBackpressure = BackpressureBlock
+	DefaultSource            = SourceMqtt
+	DefaultReplaySpeed       = 1.0
+	DefaultRecordFile        = ""
+	DefaultDxccFile          = ""
+	DefaultMetricsDxccLabels = ""
+	DefaultOpeningFactor     = 3.0
+	DefaultOpeningMinSpots   = 10
+	DefaultOpeningBaseline   = time.Duration(time.Hour * 6)
+	DefaultOpeningWebhooks   = ""
+	DefaultWatchFile         = ""
+	DefaultWatchRepeat       = time.Duration(time.Minute * 30)
+	DefaultWatchWebhooks     = ""
+	DefaultSpotsWindows      = "15m,1h,1h30m,3h,6h"
 )
 
+// Broker URL schemes understood by the MQTT client; without one, tcp:// is assumed
+var BrokerSchemes = []string{"tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss"}
+
+// Band names as they appear in PSK Reporter's MQTT topics
+var KnownBands = []string{
+	"2200m", "630m", "160m", "80m", "60m", "40m", "30m", "20m", "17m", "15m", "12m", "11m", "10m", "8m",
+	"6m", "5m", "4m", "2m", "1.25m", "70cm", "33cm", "23cm", "13cm", "9cm", "6cm", "3cm", "1.25cm",
+}
+
+// Highest DXCC entity code in ADIF's Country Codes, with some room to grow
+const MaxCountry = 999
+
+// Settings that may also be given in the configuration file, in lower case
+var Settings = []string{
+	"BROKER",
+	"BANDS",
+	"COUNTRY",
+	"METRICS_ADDRPORT",
+	"SPOTLOG_ADDRPORT",
+	"SPOTLOG_RETENTION",
+	"SPOTLOG_STORE",
+	"ACTIVE_STATIONS_WINDOWS",
+	"WATCH_CALLSIGNS",
+	"MQTT_USERNAME",
+	"MQTT_PASSWORD",
+	"MQTT_CLIENT_ID",
+	"MQTT_TLS_CA",
+	"MQTT_TLS_CERT",
+	"MQTT_TLS_KEY",
+	"MQTT_TLS_INSECURE",
+	"MQTT_QOS",
+	"MQTT_CLEAN_SESSION",
+	"MQTT_KEEPALIVE",
+	"SPOTS_BACKPRESSURE",
+	"SOURCE",
+	"REPLAY_SPEED",
+	"RECORD_FILE",
+	"DXCC_FILE",
+	"METRICS_DXCC_LABELS",
+	"OPENING_FACTOR",
+	"OPENING_MIN_SPOTS",
+	"OPENING_BASELINE",
+	"OPENING_WEBHOOKS",
+	"WATCH_FILE",
+	"WATCH_ADMIN_TOKEN",
+	"WATCH_REPEAT",
+	"WATCH_WEBHOOKS",
+	"SPOTS_WINDOWS",
+}
+
+// DXCC details that may be added as labels to the sent and received counters
+var DxccLabels = []string{"entity", "continent", "cq_zone", "itu_zone"}
+
 type Config struct {
-	Broker           string
-	Bands            []string
-	Country          int
-	Topics           []string
-	MetricsAddrPort  string
-	SpotlogAddrPort  string
-	SpotlogRetention time.Duration
+	Brokers               []string
+	Bands                 []string
+	Countries             []int
+	Topics                []string
+	MetricsAddrPort       string
+	SpotlogAddrPort       string
+	SpotlogRetention      time.Duration
+	SpotlogStore          string
+	ActiveStationsWindows []time.Duration
+	WatchCallsigns        []string
+	MqttUsername          string
+	MqttPassword          string `json:"-"`
+	MqttClientId          string
+	MqttTlsCa             string
+	MqttTlsCert           string
+	MqttTlsKey            string
+	MqttTlsInsecure       bool
+	MqttQos               byte
+	MqttCleanSession      bool
+	MqttKeepalive         time.Duration
+	SpotsBackpressure     string
+	Source                string
+	ReplayFile            string
+	ReplaySpeed           float64
+	RecordFile            string
+	DxccFile              string
+	MetricsDxccLabels     []string
+	OpeningFactor         float64
+	OpeningMinSpots       int
+	OpeningBaseline       time.Duration
+	OpeningWebhooks       []string
+	WatchFile             string
+	WatchAdminToken       string `json:"-"`
+	WatchRepeat           time.Duration
+	WatchWebhooks         []string
+	SpotsWindows          []time.Duration
 }
 
+var (
+	currentConfig *Config
+	configLock    sync.RWMutex
+)
+
 func NewConfig() *Config {
+	config, err := LoadConfig()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+
+	configLock.Lock()
+	currentConfig = config
+	configLock.Unlock()
+
+	return config
+}
+
+// CurrentConfig is the configuration as of the latest reload
+func CurrentConfig() Config {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	return *currentConfig
+}
+
+// ReloadConfig reads the configuration again and, if it's valid, makes it the
+// current one. Settings that only take effect at startup are kept as they were.
+func ReloadConfig() (Config, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return CurrentConfig(), err
+	}
+
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	// Carry over what can change at runtime, keep the rest
+	next := *currentConfig
+	next.Bands = config.Bands
+	next.Countries = config.Countries
+	next.Topics = config.Topics
+	next.SpotlogRetention = config.SpotlogRetention
+	next.SpotsWindows = config.SpotsWindows
+	next.SpotsBackpressure = config.SpotsBackpressure
+	next.OpeningFactor = config.OpeningFactor
+	next.OpeningMinSpots = config.OpeningMinSpots
+	next.OpeningBaseline = config.OpeningBaseline
+	next.OpeningWebhooks = config.OpeningWebhooks
+	next.WatchAdminToken = config.WatchAdminToken
+	next.WatchRepeat = config.WatchRepeat
+	next.WatchWebhooks = config.WatchWebhooks
+	if !reflect.DeepEqual(next, *config) {
+		log.Warn().Msg("Some settings changed, but they only take effect after a restart")
+	}
+
+	currentConfig = &next
+
+	return next, nil
+}
+
+// LoadConfig reads settings from the environment, falling back to the file
+// named in CONFIG_FILE, and then to defaults
+func LoadConfig() (*Config, error) {
 	var config Config
 
+	file, err := readConfigFile(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	getenv := func(setting string) string {
+		if value := os.Getenv(setting); value != "" {
+			return value
+		}
+		return file[setting]
+	}
+
 	// Bands
-	bands := os.Getenv("BANDS")
+	bands := getenv("BANDS")
 	if bands == "" {
-		config.Bands = strings.Split(DefaultBands, ",")
+		bands = DefaultBands
+	}
+	for _, band := range strings.Split(bands, ",") {
+		if !slices.Contains(KnownBands, band) {
+			return nil, fmt.Errorf("BANDS: unknown band %q, expecting some of %s", band, strings.Join(KnownBands, ","))
+		}
+		if !slices.Contains(config.Bands, band) {
+			config.Bands = append(config.Bands, band)
+		}
+	}
+
+	// Countries
+	countries := getenv("COUNTRY")
+	if countries == "" {
+		countries = DefaultCountries
+	}
+	for _, country := range strings.Split(countries, ",") {
+		c, err := strconv.Atoi(country)
+		if err != nil || c < 1 || c > MaxCountry {
+			return nil, fmt.Errorf("COUNTRY: %q is not an ADIF country code", country)
+		}
+		if !slices.Contains(config.Countries, c) {
+			config.Countries = append(config.Countries, c)
+		}
+	}
+
+	// MQTT topics, sent from and received in each country
+	for _, band := range config.Bands {
+		for _, country := range config.Countries {
+			config.Topics = append(config.Topics, fmt.Sprintf("pskr/filter/v2/%s/+/+/+/+/+/%d/+", band, country))
+			config.Topics = append(config.Topics, fmt.Sprintf("pskr/filter/v2/%s/+/+/+/+/+/+/%d", band, country))
+		}
+	}
+
+	// MQTT brokers, tried in order
+	mqttServers := getenv("BROKER")
+	if mqttServers == "" {
+		mqttServers = DefaultBroker
+	}
+	for _, broker := range strings.Split(mqttServers, ",") {
+		if err := validateBroker(broker); err != nil {
+			return nil, fmt.Errorf("BROKER: %w", err)
+		}
+		config.Brokers = append(config.Brokers, broker)
+	}
+
+	// MQTT credentials and client
+	config.MqttUsername = getenv("MQTT_USERNAME")
+	config.MqttPassword = getenv("MQTT_PASSWORD")
+	config.MqttClientId = getenv("MQTT_CLIENT_ID")
+
+	// MQTT TLS, for ssl:// and wss:// brokers
+	config.MqttTlsCa = getenv("MQTT_TLS_CA")
+	config.MqttTlsCert = getenv("MQTT_TLS_CERT")
+	config.MqttTlsKey = getenv("MQTT_TLS_KEY")
+	if (config.MqttTlsCert == "") != (config.MqttTlsKey == "") {
+		return nil, fmt.Errorf("MQTT_TLS_CERT and MQTT_TLS_KEY: both or neither must be given")
+	}
+	for _, path := range []string{config.MqttTlsCa, config.MqttTlsCert, config.MqttTlsKey} {
+		if _, err := os.Stat(path); path != "" && err != nil {
+			return nil, fmt.Errorf("MQTT_TLS: %w", err)
+		}
+	}
+	if mqttTlsInsecure := getenv("MQTT_TLS_INSECURE"); mqttTlsInsecure != "" {
+		if config.MqttTlsInsecure, err = strconv.ParseBool(mqttTlsInsecure); err != nil {
+			return nil, fmt.Errorf("MQTT_TLS_INSECURE: %w", err)
+		}
+	}
+
+	// MQTT QoS
+	mqttQos := getenv("MQTT_QOS")
+	if mqttQos == "" {
+		config.MqttQos = DefaultMqttQos
 	} else {
-		config.Bands = strings.Split(bands, ",")
+		if qos, err := strconv.Atoi(mqttQos); err != nil || qos < 0 || qos > 2 {
+			return nil, fmt.Errorf("MQTT_QOS: %q is not one of 0, 1, or 2", mqttQos)
+		} else {
+			config.MqttQos = byte(qos)
+		}
 	}
 
-	// Country
-	country := os.Getenv("COUNTRY")
-	if country == "" {
-		config.Country = DefaultCountry
+	// MQTT clean session; resuming one needs a fixed client ID
+	mqttCleanSession := getenv("MQTT_CLEAN_SESSION")
+	if mqttCleanSession == "" {
+		config.MqttCleanSession = DefaultMqttCleanSession
 	} else {
-		c, _ := strconv.Atoi(country)
-		config.Country = c
+		if config.MqttCleanSession, err = strconv.ParseBool(mqttCleanSession); err != nil {
+			return nil, fmt.Errorf("MQTT_CLEAN_SESSION: %w", err)
+		}
+	}
+	if !config.MqttCleanSession && config.MqttClientId == "" {
+		return nil, fmt.Errorf("MQTT_CLEAN_SESSION: a persistent session needs MQTT_CLIENT_ID")
 	}
 
-	// MQTT topics
-	for _, band := range config.Bands {
-		config.Topics = append(config.Topics, fmt.Sprintf("pskr/filter/v2/%s/+/+/+/+/+/%d/+", band, config.Country))
-		config.Topics = append(config.Topics, fmt.Sprintf("pskr/filter/v2/%s/+/+/+/+/+/+/%d", band, config.Country))
+	// MQTT keepalive
+	mqttKeepalive := getenv("MQTT_KEEPALIVE")
+	if mqttKeepalive == "" {
+		config.MqttKeepalive = DefaultMqttKeepalive
+	} else {
+		if duration, err := time.ParseDuration(mqttKeepalive); err != nil {
+			return nil, fmt.Errorf("MQTT_KEEPALIVE: %w", err)
+		} else if duration < time.Second {
+			return nil, fmt.Errorf("MQTT_KEEPALIVE: %q is less than a second", mqttKeepalive)
+		} else {
+			config.MqttKeepalive = duration
+		}
+	}
+
+	// What to do when the spotlog falls behind
+	spotsBackpressure := getenv("SPOTS_BACKPRESSURE")
+	if spotsBackpressure == "" {
+		config.SpotsBackpressure = DefaultSpotsBackpressure
+	} else if !slices.Contains(BackpressurePolicies, spotsBackpressure) {
+		return nil, fmt.Errorf("SPOTS_BACKPRESSURE: %q is not one of %s", spotsBackpressure, strings.Join(BackpressurePolicies, ","))
+	} else {
+		config.SpotsBackpressure = spotsBackpressure
+	}
+
+	// Where spots come from, MQTT or a recording of it
+	source := getenv("SOURCE")
+	if source == "" {
+		source = DefaultSource
 	}
+	if path, ok := strings.CutPrefix(source, SourceFilePrefix); ok {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("SOURCE: %w", err)
+		}
+		config.ReplayFile = path
+	} else if source != SourceMqtt {
+		return nil, fmt.Errorf("SOURCE: %q is neither %s nor %s followed by a path", source, SourceMqtt, SourceFilePrefix)
+	}
+	config.Source = source
 
-	// MQTT broker
-	mqttServer := os.Getenv("BROKER")
-	if mqttServer == "" {
-		config.Broker = DefaultBroker
+	// Replay pacing, zero for no pacing at all
+	replaySpeed := getenv("REPLAY_SPEED")
+	if replaySpeed == "" {
+		config.ReplaySpeed = DefaultReplaySpeed
 	} else {
-		config.Broker = mqttServer
+		if speed, err := strconv.ParseFloat(replaySpeed, 64); err != nil || speed < 0 {
+			return nil, fmt.Errorf("REPLAY_SPEED: %q is not a non-negative number", replaySpeed)
+		} else {
+			config.ReplaySpeed = speed
+		}
+	}
+
+	// Recording of the messages as they come, disabled unless a path is given
+	recordFile := getenv("RECORD_FILE")
+	if recordFile == "" {
+		config.RecordFile = DefaultRecordFile
+	} else if config.ReplayFile != "" {
+		// Replaying would only make another copy of the recording, or never
+		// end when the copy goes into the file being read
+		return nil, fmt.Errorf("RECORD_FILE: cannot record while replaying from %s", source)
+	} else {
+		config.RecordFile = recordFile
+	}
+
+	// DXCC prefix table, the built-in one unless a path is given
+	dxccFile := getenv("DXCC_FILE")
+	if dxccFile == "" {
+		config.DxccFile = DefaultDxccFile
+	} else {
+		if _, err := os.Stat(dxccFile); err != nil {
+			return nil, fmt.Errorf("DXCC_FILE: %w", err)
+		}
+		config.DxccFile = dxccFile
+	}
+
+	// DXCC labels on the counters, opt-in as they multiply the time series
+	metricsDxccLabels := getenv("METRICS_DXCC_LABELS")
+	if metricsDxccLabels == "" {
+		metricsDxccLabels = DefaultMetricsDxccLabels
+	}
+	for _, label := range strings.Split(metricsDxccLabels, ",") {
+		if label == "" {
+			continue
+		}
+		if !slices.Contains(DxccLabels, label) {
+			return nil, fmt.Errorf("METRICS_DXCC_LABELS: unknown label %q, expecting some of %s", label, strings.Join(DxccLabels, ","))
+		}
+		if !slices.Contains(config.MetricsDxccLabels, label) {
+			config.MetricsDxccLabels = append(config.MetricsDxccLabels, label)
+		}
+	}
+
+	// How far above the baseline a band has to go to count as open
+	openingFactor := getenv("OPENING_FACTOR")
+	if openingFactor == "" {
+		config.OpeningFactor = DefaultOpeningFactor
+	} else {
+		if factor, err := strconv.ParseFloat(openingFactor, 64); err != nil || factor <= 1 {
+			return nil, fmt.Errorf("OPENING_FACTOR: %q is not a number greater than 1", openingFactor)
+		} else {
+			config.OpeningFactor = factor
+		}
+	}
+
+	// Spots it takes, at the least, for a band to count as open
+	openingMinSpots := getenv("OPENING_MIN_SPOTS")
+	if openingMinSpots == "" {
+		config.OpeningMinSpots = DefaultOpeningMinSpots
+	} else {
+		if spots, err := strconv.Atoi(openingMinSpots); err != nil || spots < 1 {
+			return nil, fmt.Errorf("OPENING_MIN_SPOTS: %q is not a positive integer", openingMinSpots)
+		} else {
+			config.OpeningMinSpots = spots
+		}
+	}
+
+	// How far back the baseline reaches, roughly
+	openingBaseline := getenv("OPENING_BASELINE")
+	if openingBaseline == "" {
+		config.OpeningBaseline = DefaultOpeningBaseline
+	} else {
+		if duration, err := time.ParseDuration(openingBaseline); err != nil {
+			return nil, fmt.Errorf("OPENING_BASELINE: %w", err)
+		} else if duration < OpeningBucket {
+			return nil, fmt.Errorf("OPENING_BASELINE: %q is less than %s", openingBaseline, OpeningBucket)
+		} else {
+			config.OpeningBaseline = duration
+		}
+	}
+
+	// Where to post openings as they start and end
+	openingWebhooks := getenv("OPENING_WEBHOOKS")
+	if openingWebhooks == "" {
+		openingWebhooks = DefaultOpeningWebhooks
+	}
+	for _, webhook := range strings.Split(openingWebhooks, ",") {
+		if webhook == "" {
+			continue
+		}
+		if err := validateWebhook(webhook); err != nil {
+			return nil, fmt.Errorf("OPENING_WEBHOOKS: %w", err)
+		}
+		config.OpeningWebhooks = append(config.OpeningWebhooks, webhook)
+	}
+
+	// Watch list, kept in memory only unless a path is given
+	watchFile := getenv("WATCH_FILE")
+	if watchFile == "" {
+		config.WatchFile = DefaultWatchFile
+	} else {
+		config.WatchFile = watchFile
+	}
+
+	// Watch list admin API, disabled unless a token is given
+	config.WatchAdminToken = getenv("WATCH_ADMIN_TOKEN")
+
+	// How long a watch keeps quiet about more of the same
+	watchRepeat := getenv("WATCH_REPEAT")
+	if watchRepeat == "" {
+		config.WatchRepeat = DefaultWatchRepeat
+	} else {
+		if duration, err := time.ParseDuration(watchRepeat); err != nil {
+			return nil, fmt.Errorf("WATCH_REPEAT: %w", err)
+		} else if duration < 0 {
+			return nil, fmt.Errorf("WATCH_REPEAT: %q is a negative duration", watchRepeat)
+		} else {
+			config.WatchRepeat = duration
+		}
+	}
+
+	// Where to post watched spots
+	watchWebhooks := getenv("WATCH_WEBHOOKS")
+	if watchWebhooks == "" {
+		watchWebhooks = DefaultWatchWebhooks
+	}
+	for _, webhook := range strings.Split(watchWebhooks, ",") {
+		if webhook == "" {
+			continue
+		}
+		if err := validateWebhook(webhook); err != nil {
+			return nil, fmt.Errorf("WATCH_WEBHOOKS: %w", err)
+		}
+		config.WatchWebhooks = append(config.WatchWebhooks, webhook)
 	}
 
 	// Metrics' address
-	metricsAddrPort := os.Getenv("METRICS_ADDRPORT")
+	metricsAddrPort := getenv("METRICS_ADDRPORT")
 	if metricsAddrPort == "" {
 		config.MetricsAddrPort = DefaultMetricsAddrPort
 	} else {
 		config.MetricsAddrPort = metricsAddrPort
 	}
+	if err := validateAddrPort(config.MetricsAddrPort, true); err != nil {
+		return nil, fmt.Errorf("METRICS_ADDRPORT: %w", err)
+	}
 
 	// Spotlog address
-	spotlogAddrPort := os.Getenv("SPOTLOG_ADDRPORT")
+	spotlogAddrPort := getenv("SPOTLOG_ADDRPORT")
 	if spotlogAddrPort == "" {
 		config.SpotlogAddrPort = DefaultSpotlogAddrPort
 	} else {
 		config.SpotlogAddrPort = spotlogAddrPort
 	}
+	if err := validateAddrPort(config.SpotlogAddrPort, true); err != nil {
+		return nil, fmt.Errorf("SPOTLOG_ADDRPORT: %w", err)
+	}
 
 	// Spotlog retention
-	spotlogRetention := os.Getenv("SPOTLOG_RETENTION")
+	spotlogRetention := getenv("SPOTLOG_RETENTION")
 	if spotlogRetention == "" {
 		config.SpotlogRetention = DefaultSpotlogRetention
 	} else {
 		if duration, err := time.ParseDuration(spotlogRetention); err != nil {
-			log.Fatal().Err(err).Str("retention", spotlogRetention).Msg("Could not parse SPOTLOG_RETENTION")
+			return nil, fmt.Errorf("SPOTLOG_RETENTION: %w", err)
+		} else if duration <= 0 {
+			return nil, fmt.Errorf("SPOTLOG_RETENTION: %q is not a positive duration", spotlogRetention)
 		} else {
 			config.SpotlogRetention = duration
 		}
 	}
 
-	return &config
+	// Windows counted from the retained spots, no longer than they're retained;
+	// the default ones that aren't are left out, only the ones asked for refused
+	spotsWindows := getenv("SPOTS_WINDOWS")
+	defaultWindows := spotsWindows == ""
+	if defaultWindows {
+		spotsWindows = DefaultSpotsWindows
+	}
+	for _, window := range strings.Split(spotsWindows, ",") {
+		if duration, err := time.ParseDuration(window); err != nil {
+			return nil, fmt.Errorf("SPOTS_WINDOWS: %w", err)
+		} else if duration <= 0 {
+			return nil, fmt.Errorf("SPOTS_WINDOWS: %q is not a positive duration", window)
+		} else if duration > config.SpotlogRetention && defaultWindows {
+			log.Info().Dur("window", duration).Dur("retention", config.SpotlogRetention).Msg("Leaving out a default spots window longer than the spotlog retention")
+		} else if duration > config.SpotlogRetention {
+			return nil, fmt.Errorf("SPOTS_WINDOWS: %q is longer than SPOTLOG_RETENTION", window)
+		} else if !slices.Contains(config.SpotsWindows, duration) {
+			config.SpotsWindows = append(config.SpotsWindows, duration)
+		}
+	}
+	slices.Sort(config.SpotsWindows)
+
+	// Spotlog store, disabled unless a path is given
+	spotlogStore := getenv("SPOTLOG_STORE")
+	if spotlogStore == "" {
+		config.SpotlogStore = DefaultSpotlogStore
+	} else if config.ReplayFile != "" {
+		// A replay is no history to keep, nor to be mixed in with it
+		return nil, fmt.Errorf("SPOTLOG_STORE: cannot keep spots while replaying from %s", source)
+	} else {
+		config.SpotlogStore = spotlogStore
+	}
+
+	// Active station windows, disabled unless some are given
+	activeStations := getenv("ACTIVE_STATIONS_WINDOWS")
+	if activeStations == "" {
+		activeStations = DefaultActiveStations
+	}
+	for _, window := range strings.Split(activeStations, ",") {
+		if window == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(window); err != nil {
+			return nil, fmt.Errorf("ACTIVE_STATIONS_WINDOWS: %w", err)
+		} else if duration <= 0 {
+			return nil, fmt.Errorf("ACTIVE_STATIONS_WINDOWS: %q is not a positive duration", window)
+		} else if !slices.Contains(config.ActiveStationsWindows, duration) {
+			config.ActiveStationsWindows = append(config.ActiveStationsWindows, duration)
+		}
+	}
+
+	// Callsigns with counters of their own
+	watchCallsigns := getenv("WATCH_CALLSIGNS")
+	if watchCallsigns == "" {
+		watchCallsigns = DefaultWatchCallsigns
+	}
+	for _, callsign := range strings.Split(watchCallsigns, ",") {
+		callsign = strings.ToUpper(strings.TrimSpace(callsign))
+		if callsign != "" && !slices.Contains(config.WatchCallsigns, callsign) {
+			config.WatchCallsigns = append(config.WatchCallsigns, callsign)
+		}
+	}
+
+	return &config, nil
+}
+
+// Read a YAML or, by the extension, TOML configuration file into settings, with
+// lists joined by commas like they would be in the environment
+func readConfigFile(path string) (map[string]string, error) {
+	settings := make(map[string]string)
+	if path == "" {
+		return settings, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file map[string]any
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		if err := toml.Unmarshal(content, &file); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	for key, value := range file {
+		setting := strings.ToUpper(key)
+		if !slices.Contains(Settings, setting) {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		switch value := value.(type) {
+		case []any:
+			var items []string
+			for _, item := range value {
+				switch item.(type) {
+				case []any, map[string]any:
+					return nil, fmt.Errorf("%s: %s: expecting a list of plain values", path, key)
+				}
+				items = append(items, fmt.Sprint(item))
+			}
+			settings[setting] = strings.Join(items, ",")
+		case map[string]any:
+			return nil, fmt.Errorf("%s: %s: expecting a value or a list", path, key)
+		case nil:
+		default:
+			settings[setting] = fmt.Sprint(value)
+		}
+	}
+
+	return settings, nil
+}
+
+// Brokers are host:port, or URLs with a scheme and possibly a path
+func validateBroker(broker string) error {
+	if !strings.Contains(broker, "://") {
+		return validateAddrPort(broker, false)
+	}
+
+	parsed, err := url.Parse(broker)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(BrokerSchemes, parsed.Scheme) {
+		return fmt.Errorf("unknown scheme in %q, expecting one of %s", broker, strings.Join(BrokerSchemes, ","))
+	}
+	if parsed.Hostname() == "" {
+		return fmt.Errorf("missing host in %q", broker)
+	}
+	if port := parsed.Port(); port != "" {
+		return validateAddrPort(parsed.Host, false)
+	}
+	return nil
+}
+
+// Webhooks are plain HTTP(S) URLs
+func validateWebhook(webhook string) error {
+	parsed, err := url.Parse(webhook)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("%q is not an http or https URL", webhook)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("missing host in %q", webhook)
+	}
+	return nil
+}
+
+// Addresses are host:port, where the host may be left out when listening
+func validateAddrPort(addrPort string, listen bool) error {
+	host, port, err := net.SplitHostPort(addrPort)
+	if err != nil {
+		return err
+	}
+	if host == "" && !listen {
+		return fmt.Errorf("missing host in %q", addrPort)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("invalid port in %q", addrPort)
+	}
+	return nil
 }
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		fileName string
+		env      map[string]string
+		want     func(config *Config) bool
+		wantErr  bool
+	}{
+		{
+			name: "defaults",
+			want: func(config *Config) bool {
+				return reflect.DeepEqual(config.Countries, []int{224}) && len(config.Topics) == 10 &&
+					config.SpotsBackpressure == BackpressureBlock
+			},
+		},
+		{
+			name: "file",
+			file: "bands: [2m, 70cm]\ncountry: [224, 284]\nspotlog_retention: 24h\n",
+			want: func(config *Config) bool {
+				return reflect.DeepEqual(config.Bands, []string{"2m", "70cm"}) &&
+					reflect.DeepEqual(config.Countries, []int{224, 284}) &&
+					config.SpotlogRetention == 24*time.Hour && len(config.Topics) == 8
+			},
+		},
+		{
+			name:     "toml file",
+			file:     "# Just the local ones\nbands = [\"2m\", '70cm']\ncountry = [224, 284]\nspotlog_retention = \"24h\" # a day\n",
+			fileName: "config.toml",
+			want: func(config *Config) bool {
+				return reflect.DeepEqual(config.Bands, []string{"2m", "70cm"}) &&
+					reflect.DeepEqual(config.Countries, []int{224, 284}) &&
+					config.SpotlogRetention == 24*time.Hour
+			},
+		},
+		{
+			name:     "toml table",
+			file:     "[spotlog]\nretention = \"24h\"\n",
+			fileName: "config.toml",
+			wantErr:  true,
+		},
+		{
+			name: "environment overrides file",
+			file: "bands: [2m, 70cm]\n",
+			env:  map[string]string{"BANDS": "6m"},
+			want: func(config *Config) bool {
+				return reflect.DeepEqual(config.Bands, []string{"6m"})
+			},
+		},
+		{
+			name: "brokers",
+			env: map[string]string{
+				"BROKER":             "wss://relay.example.org/mqtt,ssl://relay.example.org:8883,mqtt.pskreporter.info:1883",
+				"MQTT_QOS":           "1",
+				"MQTT_CLEAN_SESSION": "false",
+				"MQTT_CLIENT_ID":     "vushf-exporter",
+			},
+			want: func(config *Config) bool {
+				return len(config.Brokers) == 3 && config.MqttQos == 1 && !config.MqttCleanSession
+			},
+		},
+		{
+			name:    "bad broker scheme",
+			env:     map[string]string{"BROKER": "http://relay.example.org"},
+			wantErr: true,
+		},
+		{
+			name:    "persistent session without client ID",
+			env:     map[string]string{"MQTT_CLEAN_SESSION": "false"},
+			wantErr: true,
+		},
+		{
+			name:    "bad QoS",
+			env:     map[string]string{"MQTT_QOS": "3"},
+			wantErr: true,
+		},
+		{
+			name: "backpressure",
+			env:  map[string]string{"SPOTS_BACKPRESSURE": "drop-oldest"},
+			want: func(config *Config) bool {
+				return config.SpotsBackpressure == BackpressureDropOldest
+			},
+		},
+		{
+			name:    "bad backpressure",
+			env:     map[string]string{"SPOTS_BACKPRESSURE": "drop-all"},
+			wantErr: true,
+		},
+		{
+			name: "replay",
+			env:  map[string]string{"SOURCE": "file:config_test.go", "REPLAY_SPEED": "0"},
+			want: func(config *Config) bool {
+				return config.ReplayFile == "config_test.go" && config.ReplaySpeed == 0
+			},
+		},
+		{
+			name:    "recording a replay",
+			env:     map[string]string{"SOURCE": "file:config_test.go", "RECORD_FILE": "config_test.go"},
+			wantErr: true,
+		},
+		{
+			name:    "storing a replay",
+			env:     map[string]string{"SOURCE": "file:config_test.go", "SPOTLOG_STORE": "spots.ndjson"},
+			wantErr: true,
+		},
+		{
+			name:    "missing replay file",
+			env:     map[string]string{"SOURCE": "file:nonexistent.ndjson"},
+			wantErr: true,
+		},
+		{
+			name:    "bad source",
+			env:     map[string]string{"SOURCE": "kafka"},
+			wantErr: true,
+		},
+		{
+			name: "DXCC labels",
+			env:  map[string]string{"METRICS_DXCC_LABELS": "continent,cq_zone,continent"},
+			want: func(config *Config) bool {
+				return reflect.DeepEqual(config.MetricsDxccLabels, []string{"continent", "cq_zone"})
+			},
+		},
+		{
+			name:    "bad DXCC label",
+			env:     map[string]string{"METRICS_DXCC_LABELS": "prefix"},
+			wantErr: true,
+		},
+		{
+			name: "openings",
+			env:  map[string]string{"OPENING_FACTOR": "2.5", "OPENING_BASELINE": "12h", "OPENING_WEBHOOKS": "https://hooks.example.org/a,http://localhost:8080/b"},
+			want: func(config *Config) bool {
+				return config.OpeningFactor == 2.5 && config.OpeningMinSpots == DefaultOpeningMinSpots &&
+					config.OpeningBaseline == 12*time.Hour && len(config.OpeningWebhooks) == 2
+			},
+		},
+		{
+			name:    "bad opening factor",
+			env:     map[string]string{"OPENING_FACTOR": "1"},
+			wantErr: true,
+		},
+		{
+			name:    "bad webhook",
+			env:     map[string]string{"OPENING_WEBHOOKS": "ftp://hooks.example.org/a"},
+			wantErr: true,
+		},
+		{
+			name: "watches",
+			env:  map[string]string{"WATCH_FILE": "/var/lib/vushf/watches.yaml", "WATCH_REPEAT": "1h", "WATCH_WEBHOOKS": "https://hooks.example.org/w"},
+			want: func(config *Config) bool {
+				return config.WatchFile == "/var/lib/vushf/watches.yaml" && config.WatchRepeat == time.Hour &&
+					config.WatchAdminToken == "" && len(config.WatchWebhooks) == 1
+			},
+		},
+		{
+			name:    "bad watch repeat",
+			env:     map[string]string{"WATCH_REPEAT": "-5m"},
+			wantErr: true,
+		},
+		{
+			name: "spots windows",
+			env:  map[string]string{"SPOTS_WINDOWS": "6h,15m,1h,15m"},
+			want: func(config *Config) bool {
+				return reflect.DeepEqual(config.SpotsWindows, []time.Duration{15 * time.Minute, time.Hour, 6 * time.Hour})
+			},
+		},
+		{
+			name:    "spots window beyond retention",
+			env:     map[string]string{"SPOTS_WINDOWS": "1h,12h", "SPOTLOG_RETENTION": "6h"},
+			wantErr: true,
+		},
+		{
+			name: "default spots windows beyond retention",
+			env:  map[string]string{"SPOTLOG_RETENTION": "1h"},
+			want: func(config *Config) bool {
+				return reflect.DeepEqual(config.SpotsWindows, []time.Duration{15 * time.Minute, time.Hour})
+			},
+		},
+		{
+			name:    "unknown setting",
+			file:    "bandz: 2m\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown band",
+			env:     map[string]string{"BANDS": "2m,3m"},
+			wantErr: true,
+		},
+		{
+			name:    "bad country",
+			env:     map[string]string{"COUNTRY": "224,OH"},
+			wantErr: true,
+		},
+		{
+			name:    "bad address",
+			env:     map[string]string{"METRICS_ADDRPORT": "9108"},
+			wantErr: true,
+		},
+		{
+			name:    "bad duration",
+			file:    "spotlog_retention: -1h\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, setting := range append(Settings, "CONFIG_FILE") {
+				t.Setenv(setting, "")
+			}
+			if tt.file != "" {
+				fileName := tt.fileName
+				if fileName == "" {
+					fileName = "config.yaml"
+				}
+				path := filepath.Join(t.TempDir(), fileName)
+				if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("CONFIG_FILE", path)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			got, err := LoadConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !tt.want(got) {
+				t.Errorf("LoadConfig() = %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+const (
+	DuplicateRetention     = time.Minute * 5
+	DuplicatePruneInterval = time.Second * 30
+)
+
+// Spots are identified by their sequence number, or if there's none, by who
+// heard whom, when, and where
+type spotKey struct {
+	SequenceNumber   uint64
+	SenderCallsign   string
+	ReceiverCallsign string
+	Time             uint64
+	Frequency        int
+}
+
+func newSpotKey(spot *Payload) spotKey {
+	if spot.SequenceNumber != 0 {
+		return spotKey{SequenceNumber: spot.SequenceNumber}
+	}
+	return spotKey{
+		SenderCallsign:   spot.SenderCallsign,
+		ReceiverCallsign: spot.ReceiverCallsign,
+		Time:             spot.Time,
+		Frequency:        spot.Frequency,
+	}
+}
+
+// Deduplicator remembers recently seen spots, as the same spot is delivered
+// once for every subscribed topic pattern it matches
+type Deduplicator struct {
+	seen      map[spotKey]time.Time
+	retention time.Duration
+	lock      sync.Mutex
+}
+
+func NewDeduplicator(retention time.Duration) *Deduplicator {
+	return &Deduplicator{
+		seen:      make(map[spotKey]time.Time),
+		retention: retention,
+	}
+}
+
+// Seen tells whether the spot has been seen already, and remembers it if not
+func (deduplicator *Deduplicator) Seen(spot *Payload) bool {
+	key := newSpotKey(spot)
+
+	deduplicator.lock.Lock()
+	defer deduplicator.lock.Unlock()
+
+	if _, seen := deduplicator.seen[key]; seen {
+		return true
+	}
+	deduplicator.seen[key] = time.Now()
+	return false
+}
+
+func (deduplicator *Deduplicator) Prune() {
+	count := 0
+	now := time.Now()
+
+	deduplicator.lock.Lock()
+	defer deduplicator.lock.Unlock()
+
+	for key, seen := range deduplicator.seen {
+		if now.Sub(seen) > deduplicator.retention {
+			delete(deduplicator.seen, key)
+			count += 1
+		}
+	}
+
+	log.Debug().Int("length", len(deduplicator.seen)).Int("pruned", count).Msg("Pruned duplicate lookup table")
+}
+
+// PruneEvery prunes until the context is done
+func (deduplicator *Deduplicator) PruneEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deduplicator.Prune()
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeduplicator(t *testing.T) {
+	deduplicator := NewDeduplicator(time.Minute)
+
+	tests := []struct {
+		name string
+		spot *Payload
+		want bool
+	}{
+		{"first", &Payload{SequenceNumber: 1, Band: "2m"}, false},
+		{"same sequence", &Payload{SequenceNumber: 1, Band: "2m"}, true},
+		{"other sequence", &Payload{SequenceNumber: 2, Band: "2m"}, false},
+		{"no sequence", &Payload{SenderCallsign: "OH2EWL", ReceiverCallsign: "SM5X", Time: 100, Frequency: 144174000}, false},
+		{"no sequence again", &Payload{SenderCallsign: "OH2EWL", ReceiverCallsign: "SM5X", Time: 100, Frequency: 144174000}, true},
+		{"no sequence, other time", &Payload{SenderCallsign: "OH2EWL", ReceiverCallsign: "SM5X", Time: 115, Frequency: 144174000}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deduplicator.Seen(tt.spot); got != tt.want {
+				t.Errorf("Seen() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	deduplicator.retention = 0
+	deduplicator.Prune()
+	if deduplicator.Seen(&Payload{SequenceNumber: 1}) {
+		t.Errorf("Seen() after Prune() = true, want false")
+	}
+}
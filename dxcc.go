@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Callsign prefixes of DXCC entities, in the CTY.CSV format of
+// https://www.country-files.com/, which DXCC_FILE can point to for a fuller
+// and more up-to-date table than this built-in one
+//
+//go:embed cty.csv
+var ctyCsv []byte
+
+// Continents as abbreviated in the prefix table
+var Continents = []string{"AF", "AN", "AS", "EU", "NA", "OC", "SA"}
+
+// DxccEntity is where a station is, as far as awards go
+type DxccEntity struct {
+	Name      string `json:"name"`
+	Adif      int    `json:"adif"`
+	Continent string `json:"continent"`
+	CqZone    int    `json:"cq"`
+	ItuZone   int    `json:"itu"`
+}
+
+// DxccTable resolves callsigns to entities by their longest matching prefix,
+// unless there's an exact match for the whole callsign
+type DxccTable struct {
+	prefixes map[string]DxccEntity
+	exact    map[string]DxccEntity
+	entities map[int]DxccEntity
+	longest  int
+}
+
+// Overrides following a prefix; zones, continent, and ones we don't care for
+var dxccOverrides = regexp.MustCompile(`\((\d+)\)|\[(\d+)\]|\{(\w+)\}|<[^>]*>|~[^~]*~`)
+
+// Suffixes that say how, not where, e.g. OH2EWL/P
+var portableSuffixes = []string{"P", "M", "QRP", "QRPP", "A", "B", "LH", "J"}
+
+var dxccTable *DxccTable
+
+func NewDxccTable(path string) (*DxccTable, error) {
+	if path == "" {
+		return ReadDxccTable(bytes.NewReader(ctyCsv))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	table, err := ReadDxccTable(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return table, nil
+}
+
+func ReadDxccTable(reader io.Reader) (*DxccTable, error) {
+	table := &DxccTable{
+		prefixes: make(map[string]DxccEntity),
+		exact:    make(map[string]DxccEntity),
+		entities: make(map[int]DxccEntity),
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		record := strings.TrimSpace(scanner.Text())
+		// Entities only counted for WAE start with an asterisk
+		if record == "" || strings.HasPrefix(record, "*") {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSuffix(record, ";"), ",", 10)
+		if len(fields) != 10 {
+			return nil, fmt.Errorf("line %d: expecting 10 fields, got %d", line, len(fields))
+		}
+
+		entity := DxccEntity{Name: fields[1], Continent: fields[3]}
+		var err error
+		if entity.Adif, err = strconv.Atoi(fields[2]); err != nil {
+			return nil, fmt.Errorf("line %d: DXCC entity code: %w", line, err)
+		}
+		if entity.CqZone, err = strconv.Atoi(fields[4]); err != nil {
+			return nil, fmt.Errorf("line %d: CQ zone: %w", line, err)
+		}
+		if entity.ItuZone, err = strconv.Atoi(fields[5]); err != nil {
+			return nil, fmt.Errorf("line %d: ITU zone: %w", line, err)
+		}
+		if _, ok := table.entities[entity.Adif]; !ok {
+			table.entities[entity.Adif] = entity
+		}
+
+		for _, alias := range strings.Fields(fields[9]) {
+			override := entity
+			for _, match := range dxccOverrides.FindAllStringSubmatch(alias, -1) {
+				switch {
+				case match[1] != "":
+					override.CqZone, _ = strconv.Atoi(match[1])
+				case match[2] != "":
+					override.ItuZone, _ = strconv.Atoi(match[2])
+				case match[3] != "":
+					override.Continent = match[3]
+				}
+			}
+			alias = dxccOverrides.ReplaceAllString(alias, "")
+
+			if exact, ok := strings.CutPrefix(alias, "="); ok {
+				table.exact[exact] = override
+				continue
+			}
+			table.prefixes[alias] = override
+			table.longest = max(table.longest, len(alias))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// Lookup finds the entity a callsign is operating from, taking into account
+// the usual portable prefixes and suffixes, e.g. DL/OH2EWL and OH2EWL/P.
+// Maritime and aeronautical mobiles aren't anywhere in particular.
+func (table *DxccTable) Lookup(callsign string) (DxccEntity, bool) {
+	callsign = strings.ToUpper(strings.TrimSpace(callsign))
+	if entity, ok := table.exact[callsign]; ok {
+		return entity, true
+	}
+
+	var parts []string
+	for _, part := range strings.Split(callsign, "/") {
+		switch {
+		case part == "":
+		case part == "MM" || part == "AM":
+			return DxccEntity{}, false
+		case len(part) == 1 && part[0] >= '0' && part[0] <= '9':
+		case len(parts) > 0 && slices.Contains(portableSuffixes, part):
+		default:
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return DxccEntity{}, false
+	}
+
+	// Of the callsign and a prefix given for operating abroad, the prefix
+	// tends to be the shorter one
+	prefixed := parts[0]
+	for _, part := range parts[1:] {
+		if len(part) < len(prefixed) {
+			prefixed = part
+		}
+	}
+
+	for length := min(len(prefixed), table.longest); length > 0; length-- {
+		if entity, ok := table.prefixes[prefixed[:length]]; ok {
+			return entity, true
+		}
+	}
+	return DxccEntity{}, false
+}
+
+// Entity by its ADIF code, as in the spots' sa and ra
+func (table *DxccTable) Entity(adif int) (DxccEntity, bool) {
+	entity, ok := table.entities[adif]
+	return entity, ok
+}
+
+// Resolve a station by its callsign, or failing that, by the entity code
+// PSK Reporter gave it
+func (table *DxccTable) Resolve(callsign string, adif int) *DxccEntity {
+	if entity, ok := table.Lookup(callsign); ok {
+		return &entity
+	}
+	if entity, ok := table.Entity(adif); ok {
+		return &entity
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDxccTable_Lookup(t *testing.T) {
+	table, err := NewDxccTable("")
+	if err != nil {
+		t.Fatalf("NewDxccTable() error = %v", err)
+	}
+
+	tests := []struct {
+		callsign string
+		want     string
+		cq       int
+		itu      int
+		ok       bool
+	}{
+		{"OH2EWL", "Finland", 15, 18, true},
+		{"oh2ewl", "Finland", 15, 18, true},
+		{"OH0Z", "Aland Islands", 15, 18, true},
+		{"OJ0B", "Market Reef", 15, 18, true},
+		{"DL/OH2EWL", "Fed. Rep. of Germany", 14, 28, true},
+		{"OH2EWL/SM", "Sweden", 14, 18, true},
+		{"OH2EWL/P", "Finland", 15, 18, true},
+		{"SM5X/7", "Sweden", 14, 18, true},
+		{"W6ABC", "United States", 3, 6, true},
+		{"KA1ABC", "United States", 5, 8, true},
+		{"KH6XX", "Hawaii", 31, 61, true},
+		{"RA9ABC", "Asiatic Russia", 17, 30, true},
+		{"UA2FAA", "Kaliningrad", 15, 29, true},
+		{"UA3ABC", "European Russia", 16, 29, true},
+		{"VK6ABC", "Australia", 29, 58, true},
+		{"EA8ABC", "Canary Islands", 33, 36, true},
+		{"G4ABC", "England", 14, 27, true},
+		{"GM4ABC", "Scotland", 14, 27, true},
+		{"OH2EWL/MM", "", 0, 0, false},
+		{"Q1ABC", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.callsign, func(t *testing.T) {
+			got, ok := table.Lookup(tt.callsign)
+			if ok != tt.ok || got.Name != tt.want || got.CqZone != tt.cq || got.ItuZone != tt.itu {
+				t.Errorf("Lookup() = %+v, %v, want %s CQ %d ITU %d, %v", got, ok, tt.want, tt.cq, tt.itu, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDxccTable_Resolve(t *testing.T) {
+	table, err := ReadDxccTable(strings.NewReader("OH,Finland,224,EU,15,18,63.78,-27.08,-2.0,OF OG OH OI OJ =OH2EWL/MM{AF}(33)[37];\n*OH/w,Somewhere,999,EU,1,1,0.00,0.00,0.0,OH9;\n"))
+	if err != nil {
+		t.Fatalf("ReadDxccTable() error = %v", err)
+	}
+
+	if entity := table.Resolve("OG55W", 0); entity == nil || entity.Adif != 224 {
+		t.Errorf("Resolve() by callsign = %+v, want Finland", entity)
+	}
+	if entity := table.Resolve("XX1XX", 224); entity == nil || entity.Name != "Finland" {
+		t.Errorf("Resolve() by code = %+v, want Finland", entity)
+	}
+	if entity := table.Resolve("XX1XX", 284); entity != nil {
+		t.Errorf("Resolve() = %+v, want nothing", entity)
+	}
+	if entity := table.Resolve("OH2EWL/MM", 0); entity == nil || entity.Continent != "AF" || entity.CqZone != 33 || entity.ItuZone != 37 {
+		t.Errorf("Resolve() exact = %+v, want overrides", entity)
+	}
+	if _, ok := table.Entity(999); ok {
+		t.Errorf("Entity() found a WAE-only entity")
+	}
+}
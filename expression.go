@@ -0,0 +1,507 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MaxExpressionLength = 1024
+	MaxExpressionDepth  = 32
+)
+
+// Expression is a parsed ?q=, e.g.
+//
+//	band in (2m, 70cm) and (distance > 500 or mode = MSK144) and not rc ~ "OH2*"
+//
+// Comparisons are joined with and, or, and not, in that order of precedence,
+// and grouped with parentheses. Text compares case-insensitively, and ~ takes
+// a pattern where * matches anything and ? any one character.
+type Expression interface {
+	Eval(spot *Payload) bool
+	String() string
+}
+
+// ExpressionError tells where parsing went wrong, counting from one
+type ExpressionError struct {
+	Position int
+	Message  string
+}
+
+func (err *ExpressionError) Error() string {
+	return fmt.Sprintf("at %d: %s", err.Position, err.Message)
+}
+
+// Spot fields as they're known in expressions, numeric or text; either way,
+// a field may have no value, e.g. a distance without locators, and then no
+// comparison holds
+type expressionField struct {
+	name    string
+	numeric func(spot *Payload, countries []int) (float64, bool)
+	text    func(spot *Payload, countries []int) []string
+}
+
+func textField(value func(spot *Payload) string) func(*Payload, []int) []string {
+	return func(spot *Payload, countries []int) []string {
+		if text := value(spot); text != "" {
+			return []string{text}
+		}
+		return nil
+	}
+}
+
+func dxccField(value func(spot *Payload) *DxccEntity, part func(entity *DxccEntity) string) func(*Payload, []int) []string {
+	return textField(func(spot *Payload) string {
+		if entity := value(spot); entity != nil {
+			return part(entity)
+		}
+		return ""
+	})
+}
+
+var expressionFields = []expressionField{
+	{name: "band", text: textField(func(spot *Payload) string { return spot.Band })},
+	{name: "mode", text: textField(func(spot *Payload) string { return spot.Mode })},
+	{name: "report", numeric: func(spot *Payload, countries []int) (float64, bool) { return float64(spot.Report), true }},
+	{name: "distance", numeric: func(spot *Payload, countries []int) (float64, bool) {
+		return float64(spot.Distance), spot.Distance != 0 || spot.located
+	}},
+	{name: "freq", numeric: func(spot *Payload, countries []int) (float64, bool) { return float64(spot.Frequency) / 1000000, true }},
+	{name: "time", numeric: func(spot *Payload, countries []int) (float64, bool) { return float64(spot.Time), true }},
+	{name: "sc", text: textField(func(spot *Payload) string { return spot.SenderCallsign })},
+	{name: "rc", text: textField(func(spot *Payload) string { return spot.ReceiverCallsign })},
+	{name: "sl", text: textField(func(spot *Payload) string { return spot.SenderLocator })},
+	{name: "rl", text: textField(func(spot *Payload) string { return spot.ReceiverLocator })},
+	{name: "sa", numeric: func(spot *Payload, countries []int) (float64, bool) { return float64(spot.SenderCountry), true }},
+	{name: "ra", numeric: func(spot *Payload, countries []int) (float64, bool) { return float64(spot.ReceiverCountry), true }},
+	{name: "sender_continent", text: dxccField(func(spot *Payload) *DxccEntity { return spot.SenderDxcc }, func(entity *DxccEntity) string { return entity.Continent })},
+	{name: "receiver_continent", text: dxccField(func(spot *Payload) *DxccEntity { return spot.ReceiverDxcc }, func(entity *DxccEntity) string { return entity.Continent })},
+	{name: "sender_entity", text: dxccField(func(spot *Payload) *DxccEntity { return spot.SenderDxcc }, func(entity *DxccEntity) string { return entity.Name })},
+	{name: "receiver_entity", text: dxccField(func(spot *Payload) *DxccEntity { return spot.ReceiverDxcc }, func(entity *DxccEntity) string { return entity.Name })},
+	{name: "direction", text: func(spot *Payload, countries []int) []string {
+		var directions []string
+		for _, classification := range Classify(Config{Countries: countries}, spot) {
+			directions = append(directions, classification.Direction)
+		}
+		return directions
+	}},
+}
+
+// Longer names for the same fields, the short ones being as in the JSON
+var expressionAliases = map[string]string{
+	"b":                 "band",
+	"md":                "mode",
+	"rp":                "report",
+	"mhz":               "freq",
+	"t":                 "time",
+	"sender":            "sc",
+	"receiver":          "rc",
+	"sender_callsign":   "sc",
+	"receiver_callsign": "rc",
+	"sender_locator":    "sl",
+	"receiver_locator":  "rl",
+	"sender_country":    "sa",
+	"receiver_country":  "ra",
+}
+
+var expressionOperators = []string{"=", "!=", "<", "<=", ">", ">=", "~", "in"}
+
+type orExpression struct {
+	left, right Expression
+}
+
+func (expression *orExpression) Eval(spot *Payload) bool {
+	return expression.left.Eval(spot) || expression.right.Eval(spot)
+}
+
+func (expression *orExpression) String() string {
+	return "(" + expression.left.String() + " or " + expression.right.String() + ")"
+}
+
+type andExpression struct {
+	left, right Expression
+}
+
+func (expression *andExpression) Eval(spot *Payload) bool {
+	return expression.left.Eval(spot) && expression.right.Eval(spot)
+}
+
+func (expression *andExpression) String() string {
+	return "(" + expression.left.String() + " and " + expression.right.String() + ")"
+}
+
+type notExpression struct {
+	operand Expression
+}
+
+func (expression *notExpression) Eval(spot *Payload) bool {
+	return !expression.operand.Eval(spot)
+}
+
+func (expression *notExpression) String() string {
+	return "not " + expression.operand.String()
+}
+
+type comparison struct {
+	field     *expressionField
+	operator  string
+	values    []string
+	numbers   []float64
+	countries []int
+}
+
+func (expression *comparison) Eval(spot *Payload) bool {
+	if expression.field.numeric != nil {
+		value, ok := expression.field.numeric(spot, expression.countries)
+		if !ok {
+			return false
+		}
+		switch expression.operator {
+		case "=", "in":
+			return slices.Contains(expression.numbers, value)
+		case "!=":
+			return value != expression.numbers[0]
+		case "<":
+			return value < expression.numbers[0]
+		case "<=":
+			return value <= expression.numbers[0]
+		case ">":
+			return value > expression.numbers[0]
+		case ">=":
+			return value >= expression.numbers[0]
+		}
+		return false
+	}
+
+	texts := expression.field.text(spot, expression.countries)
+	if texts == nil {
+		return false
+	}
+	switch expression.operator {
+	case "!=":
+		return !slices.ContainsFunc(texts, func(text string) bool { return strings.EqualFold(text, expression.values[0]) })
+	case "~":
+		return slices.ContainsFunc(texts, func(text string) bool { return globMatch(strings.ToUpper(expression.values[0]), strings.ToUpper(text)) })
+	}
+	return slices.ContainsFunc(texts, func(text string) bool {
+		return slices.ContainsFunc(expression.values, func(value string) bool { return strings.EqualFold(text, value) })
+	})
+}
+
+func (expression *comparison) String() string {
+	values := make([]string, len(expression.values))
+	for i, value := range expression.values {
+		values[i] = quoteExpressionValue(value)
+	}
+	if expression.operator == "in" {
+		return expression.field.name + " in (" + strings.Join(values, ", ") + ")"
+	}
+	return expression.field.name + " " + expression.operator + " " + values[0]
+}
+
+// Quote a value the way the tokenizer reads it back, escaping only quotes
+// and backslashes
+func quoteExpressionValue(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// Match * to anything and ? to any one character, with backtracking only to
+// the latest *
+func globMatch(pattern string, text string) bool {
+	p, t := 0, 0
+	star, resume := -1, 0
+	for t < len(text) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, resume = p, t
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == text[t]):
+			if pattern[p] == '?' {
+				_, size := utf8.DecodeRuneInString(text[t:])
+				t += size
+			} else {
+				t++
+			}
+			p++
+		case star >= 0:
+			p = star + 1
+			resume++
+			t = resume
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+type expressionToken struct {
+	text     string
+	quoted   bool
+	position int
+}
+
+// Words run until whitespace or one of these, unless quoted
+const expressionDelimiters = "()=!<>~,\""
+
+func tokenizeExpression(input string) ([]expressionToken, error) {
+	var tokens []expressionToken
+	for i := 0; i < len(input); {
+		switch c := input[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == ',' || c == '=' || c == '~':
+			tokens = append(tokens, expressionToken{text: input[i : i+1], position: i + 1})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			if i+1 < len(input) && input[i+1] == '=' {
+				tokens = append(tokens, expressionToken{text: input[i : i+2], position: i + 1})
+				i += 2
+			} else if c == '!' {
+				return nil, &ExpressionError{i + 1, "expecting != "}
+			} else {
+				tokens = append(tokens, expressionToken{text: input[i : i+1], position: i + 1})
+				i++
+			}
+		case c == '"':
+			start := i
+			var text strings.Builder
+			for i++; ; i++ {
+				if i >= len(input) {
+					return nil, &ExpressionError{start + 1, "unterminated string"}
+				}
+				if input[i] == '\\' && i+1 < len(input) {
+					i++
+				} else if input[i] == '"' {
+					break
+				}
+				text.WriteByte(input[i])
+			}
+			i++
+			tokens = append(tokens, expressionToken{text: text.String(), quoted: true, position: start + 1})
+		default:
+			start := i
+			for i < len(input) && !strings.ContainsRune(" \t\n\r"+expressionDelimiters, rune(input[i])) {
+				i++
+			}
+			tokens = append(tokens, expressionToken{text: input[start:i], position: start + 1})
+		}
+	}
+	return tokens, nil
+}
+
+type expressionParser struct {
+	tokens    []expressionToken
+	next      int
+	depth     int
+	end       int
+	countries []int
+}
+
+// ParseExpression parses a ?q= expression; directions are relative to the
+// given countries
+func ParseExpression(input string, countries []int) (Expression, error) {
+	if len(input) > MaxExpressionLength {
+		return nil, &ExpressionError{MaxExpressionLength + 1, fmt.Sprintf("expression longer than %d", MaxExpressionLength)}
+	}
+	tokens, err := tokenizeExpression(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &ExpressionError{1, "empty expression"}
+	}
+
+	parser := &expressionParser{tokens: tokens, end: len(input) + 1, countries: countries}
+	expression, err := parser.or()
+	if err != nil {
+		return nil, err
+	}
+	if token, ok := parser.peek(); ok {
+		return nil, &ExpressionError{token.position, fmt.Sprintf("unexpected %q, expecting and, or, or the end", token.text)}
+	}
+	return expression, nil
+}
+
+func (parser *expressionParser) peek() (expressionToken, bool) {
+	if parser.next >= len(parser.tokens) {
+		return expressionToken{position: parser.end}, false
+	}
+	return parser.tokens[parser.next], true
+}
+
+// Keywords are plain, unquoted words
+func (parser *expressionParser) keyword(keyword string) bool {
+	token, ok := parser.peek()
+	if ok && !token.quoted && strings.EqualFold(token.text, keyword) {
+		parser.next++
+		return true
+	}
+	return false
+}
+
+func (parser *expressionParser) punctuation(punctuation string) bool {
+	token, ok := parser.peek()
+	if ok && !token.quoted && token.text == punctuation {
+		parser.next++
+		return true
+	}
+	return false
+}
+
+func (parser *expressionParser) or() (Expression, error) {
+	left, err := parser.and()
+	if err != nil {
+		return nil, err
+	}
+	for parser.keyword("or") {
+		right, err := parser.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpression{left, right}
+	}
+	return left, nil
+}
+
+func (parser *expressionParser) and() (Expression, error) {
+	left, err := parser.not()
+	if err != nil {
+		return nil, err
+	}
+	for parser.keyword("and") {
+		right, err := parser.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpression{left, right}
+	}
+	return left, nil
+}
+
+func (parser *expressionParser) not() (Expression, error) {
+	parser.depth++
+	defer func() { parser.depth-- }()
+	if parser.depth > MaxExpressionDepth {
+		token, _ := parser.peek()
+		return nil, &ExpressionError{token.position, fmt.Sprintf("nested deeper than %d", MaxExpressionDepth)}
+	}
+
+	if parser.keyword("not") {
+		operand, err := parser.not()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpression{operand}, nil
+	}
+
+	if parser.punctuation("(") {
+		expression, err := parser.or()
+		if err != nil {
+			return nil, err
+		}
+		if !parser.punctuation(")") {
+			token, _ := parser.peek()
+			return nil, &ExpressionError{token.position, "expecting )"}
+		}
+		return expression, nil
+	}
+
+	return parser.comparison()
+}
+
+func (parser *expressionParser) comparison() (Expression, error) {
+	token, ok := parser.peek()
+	if !ok || token.quoted || strings.ContainsAny(token.text, expressionDelimiters) {
+		return nil, &ExpressionError{token.position, "expecting a field, not, or ("}
+	}
+	name := strings.ToLower(token.text)
+	if alias, ok := expressionAliases[name]; ok {
+		name = alias
+	}
+	index := slices.IndexFunc(expressionFields, func(field expressionField) bool { return field.name == name })
+	if index < 0 {
+		var names []string
+		for _, field := range expressionFields {
+			names = append(names, field.name)
+		}
+		return nil, &ExpressionError{token.position, fmt.Sprintf("unknown field %q, expecting one of %s", token.text, strings.Join(names, ", "))}
+	}
+	parser.next++
+	expression := &comparison{field: &expressionFields[index], countries: parser.countries}
+
+	token, ok = parser.peek()
+	if !ok || token.quoted || !slices.Contains(expressionOperators, strings.ToLower(token.text)) {
+		return nil, &ExpressionError{token.position, fmt.Sprintf("expecting one of %s after %s", strings.Join(expressionOperators, " "), expression.field.name)}
+	}
+	expression.operator = strings.ToLower(token.text)
+	parser.next++
+
+	if expression.field.numeric != nil && expression.operator == "~" {
+		return nil, &ExpressionError{token.position, fmt.Sprintf("%s is a number, ~ only works with text", expression.field.name)}
+	}
+	if expression.field.text != nil && strings.ContainsAny(expression.operator, "<>") {
+		return nil, &ExpressionError{token.position, fmt.Sprintf("%s is text, %s only works with numbers", expression.field.name, expression.operator)}
+	}
+
+	if expression.operator == "in" {
+		if !parser.punctuation("(") {
+			token, _ := parser.peek()
+			return nil, &ExpressionError{token.position, "expecting ( after in"}
+		}
+		for {
+			if err := parser.value(expression); err != nil {
+				return nil, err
+			}
+			if parser.punctuation(")") {
+				break
+			}
+			if !parser.punctuation(",") {
+				token, _ := parser.peek()
+				return nil, &ExpressionError{token.position, "expecting , or )"}
+			}
+		}
+		return expression, nil
+	}
+
+	if err := parser.value(expression); err != nil {
+		return nil, err
+	}
+	return expression, nil
+}
+
+func (parser *expressionParser) value(expression *comparison) error {
+	token, ok := parser.peek()
+	if !ok || (!token.quoted && (token.text == "" || strings.ContainsAny(token.text, expressionDelimiters))) {
+		return &ExpressionError{token.position, fmt.Sprintf("expecting a value for %s", expression.field.name)}
+	}
+	parser.next++
+
+	if expression.field.numeric != nil {
+		var number float64
+		var err error
+		if expression.field.name == "time" {
+			var timestamp uint64
+			timestamp, err = parseTimestamp(token.text)
+			number = float64(timestamp)
+		} else {
+			number, err = strconv.ParseFloat(token.text, 64)
+			if err == nil && (math.IsNaN(number) || math.IsInf(number, 0)) {
+				err = fmt.Errorf("not a finite number")
+			}
+		}
+		if err != nil || token.text == "" {
+			return &ExpressionError{token.position, fmt.Sprintf("%s is a number, not %q", expression.field.name, token.text)}
+		}
+		expression.numbers = append(expression.numbers, number)
+	}
+	expression.values = append(expression.values, token.text)
+
+	return nil
+}
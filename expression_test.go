@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "comparison",
+			input: "band = 2m",
+			want:  `band = "2m"`,
+		},
+		{
+			name:  "precedence",
+			input: "band in (2m,70cm) and (distance > 500 or mode = MSK144) and not rc ~ \"OH2*\"",
+			want:  `((band in ("2m", "70cm") and (distance > "500" or mode = "MSK144")) and not rc ~ "OH2*")`,
+		},
+		{
+			name:  "or binds looser",
+			input: "b = 2m or b = 6m and md = FT8",
+			want:  `(band = "2m" or (band = "6m" and mode = "FT8"))`,
+		},
+		{
+			name:  "aliases and case",
+			input: "NOT Sender ~ oh* AND t >= 2023-11-14T22:13:20Z",
+			want:  `(not sc ~ "oh*" and time >= "2023-11-14T22:13:20Z")`,
+		},
+		{
+			name:  "quoted",
+			input: `sender_entity = "Aland \"Is.\""`,
+			want:  `sender_entity = "Aland \"Is.\""`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := ParseExpression(tt.input, nil)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+			if got := expression.String(); got != tt.want {
+				t.Errorf("ParseExpression() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", " ", "at 1: empty expression"},
+		{"unknown field", "band = 2m and colour = red", `at 15: unknown field "colour", expecting one of band, mode, report, distance, freq, time, sc, rc, sl, rl, sa, ra, sender_continent, receiver_continent, sender_entity, receiver_entity, direction`},
+		{"missing value", "distance >", "at 11: expecting a value for distance"},
+		{"not a number", "distance > far", `at 12: distance is a number, not "far"`},
+		{"ordering text", "band < 2m", "at 6: band is text, < only works with numbers"},
+		{"pattern number", "report ~ 1*", "at 8: report is a number, ~ only works with text"},
+		{"unbalanced", "(band = 2m", "at 11: expecting )"},
+		{"trailing", "band = 2m mode = FT8", `at 11: unexpected "mode", expecting and, or, or the end`},
+		{"list", "band in (2m 6m)", "at 13: expecting , or )"},
+		{"unterminated", `rc ~ "OH2*`, "at 6: unterminated string"},
+		{"bang", "band ! 2m", "at 6: expecting != "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseExpression(tt.input, nil)
+			var expressionError *ExpressionError
+			if !errors.As(err, &expressionError) {
+				t.Fatalf("ParseExpression() error = %v, want an ExpressionError", err)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("ParseExpression() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionEval(t *testing.T) {
+	fromKp20 := &Payload{Band: "2m", Mode: "FT8", SenderLocator: "KP20le", SenderCallsign: "OH2XYZ", SenderCountry: 224, ReceiverCountry: 284, Distance: 400, located: true, Frequency: 144174000, Report: -12}
+	far := &Payload{Band: "70cm", Mode: "FT8", SenderLocator: "JO89", SenderCallsign: "SM5ABC", SenderCountry: 284, ReceiverCountry: 284, Distance: 1200, located: true, ReceiverDxcc: &DxccEntity{Name: "Sweden", Continent: "EU"}}
+	unlocated := &Payload{Band: "70cm", Mode: "FT8", SenderCountry: 284, ReceiverCountry: 284}
+
+	tests := []struct {
+		name  string
+		input string
+		spot  *Payload
+		want  bool
+	}{
+		{"either, first", `(band = 2m and mode = ft8 and sl ~ "KP20*") or (band = 70cm and distance > 1000)`, fromKp20, true},
+		{"either, second", `(band = 2m and mode = ft8 and sl ~ "KP20*") or (band = 70cm and distance > 1000)`, far, true},
+		{"either, neither", `(band = 2m and mode = ft8 and sl ~ "KP20*") or (band = 70cm and distance > 1000)`, unlocated, false},
+		{"unknown distance", "distance < 1000", unlocated, false},
+		{"negated unknown", "not distance < 1000", unlocated, true},
+		{"not equal", "sc != oh2xyz", fromKp20, false},
+		{"pattern", "sc ~ OH?X*", fromKp20, true},
+		{"frequency", "freq >= 144.174 and freq <= 144.175", fromKp20, true},
+		{"report", "report in (-12, -13)", fromKp20, true},
+		{"entity", "receiver_entity = sweden and receiver_continent = EU", far, true},
+		{"no entity", "receiver_entity != sweden", fromKp20, false},
+		{"direction", "direction = sent", fromKp20, true},
+		{"local", "direction in (local)", far, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := ParseExpression(tt.input, []int{224})
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+			if got := expression.Eval(tt.spot); got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"OH2*", "OH2XYZ", true},
+		{"OH2*", "OH6XYZ", false},
+		{"*/P", "OH2XYZ/P", true},
+		{"*", "", true},
+		{"?", "", false},
+		{"?H*Z", "OH2XYZ", true},
+		{"*A*B", "AXBXAB", true},
+		{"*A*B", "AXBXA", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.text); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+// Whatever goes in, parsing must not panic, and whatever parses must come out
+// the same after another round
+func FuzzParseExpression(f *testing.F) {
+	for _, seed := range []string{
+		"band = 2m",
+		`band in (2m,70cm) and (distance > 500 or mode = MSK144) and not rc ~ "OH2*"`,
+		"not not (t >= 1700000000 or freq < 50.3)",
+		`sender_entity = "Aland \"Is.\""`,
+		"((((",
+		"direction != local",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		expression, err := ParseExpression(input, []int{224})
+		if err != nil {
+			var expressionError *ExpressionError
+			if !errors.As(err, &expressionError) {
+				t.Fatalf("ParseExpression(%q) error = %v, want an ExpressionError", input, err)
+			}
+			return
+		}
+		again, err := ParseExpression(expression.String(), []int{224})
+		if err != nil {
+			t.Fatalf("ParseExpression(%q) error = %v, from %q", expression.String(), err, input)
+		}
+		if again.String() != expression.String() {
+			t.Errorf("ParseExpression(%q) = %v, want %v", expression.String(), again.String(), expression.String())
+		}
+		expression.Eval(&Payload{})
+	})
+}
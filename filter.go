@@ -1,33 +1,81 @@
 package main
 
 import (
+	"fmt"
 	"github.com/rs/zerolog/log"
+	"math"
 	"net/http"
+	"net/url"
 	"slices"
+	"strconv"
 	"strings"
 )
 
 const (
-	MaxModeNameLength = 8
-	MaxModeCount      = 8
-	MaxLocatorLength  = 16
-	MaxCallsignLength = 16
+	MaxModeNameLength   = 8
+	MaxModeCount        = 8
+	MaxLocatorLength    = 16
+	MaxCallsignLength   = 16
+	MaxEntityCount      = 8
+	MaxEntityNameLength = 40
 )
 
+var Directions = []string{DirectionSent, DirectionReceived, DirectionLocal}
+
+// Parameters the filter goes by, any others being left alone
+var FilterParameters = []string{
+	"bands", "modes", "continent", "entity", "direction",
+	"locator", "callsign", "sender_locator", "sender_callsign", "receiver_locator", "receiver_callsign",
+	"min_distance", "max_distance", "min_report", "max_report", "min_freq", "max_freq",
+	"since", "until", "q",
+}
+
 type Filter struct {
-	Enabled  bool
-	Locator  string
-	Callsign string
-	Bands    []string
-	Modes    []string
+	Enabled          bool
+	Locator          string
+	Callsign         string
+	SenderLocator    string
+	SenderCallsign   string
+	ReceiverLocator  string
+	ReceiverCallsign string
+	Bands            []string
+	Modes            []string
+	Continents       []string
+	Entities         []string
+	Directions       []string
+	MinDistance      *int64
+	MaxDistance      *int64
+	MinReport        *int
+	MaxReport        *int
+	MinFrequency     *float64
+	MaxFrequency     *float64
+	Since            uint64
+	Until            uint64
+	Query            string
+
+	// Directions are relative to these
+	countries []int
+
+	// Query, parsed, or why it couldn't be
+	expression Expression
+	err        error
 }
 
 func NewFilter(config Config, request *http.Request) Filter {
+	return newFilter(config, request.URL.Query())
+}
+
+func newFilter(config Config, query url.Values) Filter {
+	prefix := func(parameter string, length int) string {
+		value := query.Get(parameter)
+		return value[:min(len(value), length)]
+	}
+
 	filter := Filter{
 		Enabled: false,
 		Bands: func() []string {
 			var bands []string
-			for _, band := range strings.Split(request.URL.Query().Get("bands"), ",") {
+			for _, band := range strings.Split(query.Get("bands"), ",") {
 				if slices.Contains(config.Bands, band) && !slices.Contains(bands, band) {
 					bands = append(bands, band)
 				}
@@ -36,24 +84,75 @@ func NewFilter(config Config, request *http.Request) Filter {
 		}(),
 		Modes: func() []string {
 			var modes []string
-			for _, mode := range strings.Split(request.URL.Query().Get("modes"), ",") {
+			for _, mode := range strings.Split(query.Get("modes"), ",") {
 				if mode != "" && len(mode) <= MaxModeNameLength && !slices.Contains(modes, mode) {
 					modes = append(modes, mode)
 				}
 			}
 			return modes[:min(len(modes), MaxModeCount)]
 		}(),
-		Locator: func() string {
-			locator := request.URL.Query().Get("locator")
-			return locator[:min(len(locator), MaxLocatorLength)]
+		Continents: func() []string {
+			var continents []string
+			for _, continent := range strings.Split(strings.ToUpper(query.Get("continent")), ",") {
+				if slices.Contains(Continents, continent) && !slices.Contains(continents, continent) {
+					continents = append(continents, continent)
+				}
+			}
+			return continents
+		}(),
+		// Entity names or ADIF codes
+		Entities: func() []string {
+			var entities []string
+			for _, entity := range strings.Split(query.Get("entity"), ",") {
+				entity = strings.TrimSpace(entity)
+				if entity != "" && len(entity) <= MaxEntityNameLength && !slices.Contains(entities, entity) {
+					entities = append(entities, entity)
+				}
+			}
+			return entities[:min(len(entities), MaxEntityCount)]
 		}(),
-		Callsign: func() string {
-			callsign := request.URL.Query().Get("callsign")
-			return callsign[:min(len(callsign), MaxCallsignLength)]
+		Directions: func() []string {
+			var directions []string
+			for _, direction := range strings.Split(query.Get("direction"), ",") {
+				if slices.Contains(Directions, direction) && !slices.Contains(directions, direction) {
+					directions = append(directions, direction)
+				}
+			}
+			return directions
 		}(),
+		Locator:          prefix("locator", MaxLocatorLength),
+		Callsign:         prefix("callsign", MaxCallsignLength),
+		SenderLocator:    prefix("sender_locator", MaxLocatorLength),
+		SenderCallsign:   prefix("sender_callsign", MaxCallsignLength),
+		ReceiverLocator:  prefix("receiver_locator", MaxLocatorLength),
+		ReceiverCallsign: prefix("receiver_callsign", MaxCallsignLength),
+		MinDistance:      parseBound(query.Get("min_distance"), func(value string) (int64, error) { return strconv.ParseInt(value, 10, 64) }),
+		MaxDistance:      parseBound(query.Get("max_distance"), func(value string) (int64, error) { return strconv.ParseInt(value, 10, 64) }),
+		MinReport:        parseBound(query.Get("min_report"), strconv.Atoi),
+		MaxReport:        parseBound(query.Get("max_report"), strconv.Atoi),
+		MinFrequency:     parseBound(query.Get("min_freq"), func(value string) (float64, error) { return strconv.ParseFloat(value, 64) }),
+		MaxFrequency:     parseBound(query.Get("max_freq"), func(value string) (float64, error) { return strconv.ParseFloat(value, 64) }),
+		countries:        config.Countries,
 	}
 
-	if filter.Bands != nil || filter.Modes != nil || filter.Locator != "" || filter.Callsign != "" {
+	// Like the other parameters, unparseable times are ignored here, while the
+	// API complains about them
+	filter.Since, _ = parseTimestamp(query.Get("since"))
+	filter.Until, _ = parseTimestamp(query.Get("until"))
+
+	// Unlike the others, a query that doesn't parse is refused, since there's
+	// no telling which part of it was meant
+	if filter.Query = query.Get("q"); filter.Query != "" {
+		if filter.expression, filter.err = ParseExpression(filter.Query, config.Countries); filter.err != nil {
+			filter.err = fmt.Errorf("q: %w", filter.err)
+		}
+	}
+
+	if filter.Bands != nil || filter.Modes != nil || filter.Continents != nil || filter.Entities != nil || filter.Directions != nil ||
+		filter.Locator != "" || filter.Callsign != "" ||
+		filter.SenderLocator != "" || filter.SenderCallsign != "" || filter.ReceiverLocator != "" || filter.ReceiverCallsign != "" ||
+		filter.MinDistance != nil || filter.MaxDistance != nil || filter.MinReport != nil || filter.MaxReport != nil ||
+		filter.MinFrequency != nil || filter.MaxFrequency != nil || filter.Since != 0 || filter.Until != 0 || filter.expression != nil {
 		filter.Enabled = true
 	}
 
@@ -62,7 +161,25 @@ func NewFilter(config Config, request *http.Request) Filter {
 	return filter
 }
 
-func (filter *Filter) filter(spot Payload) bool {
+// Err tells why the query couldn't be parsed, if it couldn't
+func (filter Filter) Err() error {
+	return filter.err
+}
+
+// A lower or upper bound, if given and a finite number, as nothing compares
+// with NaN
+func parseBound[T int | int64 | float64](value string, parse func(string) (T, error)) *T {
+	if value == "" {
+		return nil
+	}
+	bound, err := parse(value)
+	if err != nil || math.IsNaN(float64(bound)) || math.IsInf(float64(bound), 0) {
+		return nil
+	}
+	return &bound
+}
+
+func (filter *Filter) filter(spot *Payload) bool {
 	// Band
 	if filter.Bands != nil && !slices.Contains(filter.Bands, spot.Band) {
 		return false
@@ -73,15 +190,152 @@ func (filter *Filter) filter(spot Payload) bool {
 		return false
 	}
 
+	// Time
+	if filter.Since != 0 && spot.Time < filter.Since {
+		return false
+	}
+	if filter.Until != 0 && spot.Time > filter.Until {
+		return false
+	}
+
+	// Distance, only when there's one to go by
+	if (filter.MinDistance != nil || filter.MaxDistance != nil) && spot.Distance == 0 && !spot.located {
+		return false
+	}
+	if filter.MinDistance != nil && spot.Distance < *filter.MinDistance {
+		return false
+	}
+	if filter.MaxDistance != nil && spot.Distance > *filter.MaxDistance {
+		return false
+	}
+
+	// Report
+	if filter.MinReport != nil && spot.Report < *filter.MinReport {
+		return false
+	}
+	if filter.MaxReport != nil && spot.Report > *filter.MaxReport {
+		return false
+	}
+
+	// Frequency, in MHz
+	if filter.MinFrequency != nil && float64(spot.Frequency)/1000000 < *filter.MinFrequency {
+		return false
+	}
+	if filter.MaxFrequency != nil && float64(spot.Frequency)/1000000 > *filter.MaxFrequency {
+		return false
+	}
+
+	// Direction, relative to any of the monitored countries
+	if filter.Directions != nil && !slices.ContainsFunc(Classify(Config{Countries: filter.countries}, spot), func(classification Classification) bool {
+		return slices.Contains(filter.Directions, classification.Direction)
+	}) {
+		return false
+	}
+
+	// Continent, of either end
+	if filter.Continents != nil && !(spot.SenderDxcc != nil && slices.Contains(filter.Continents, spot.SenderDxcc.Continent) ||
+		spot.ReceiverDxcc != nil && slices.Contains(filter.Continents, spot.ReceiverDxcc.Continent)) {
+		return false
+	}
+
+	// Entity, of either end
+	if filter.Entities != nil && !(matchesEntity(filter.Entities, spot.SenderDxcc) || matchesEntity(filter.Entities, spot.ReceiverDxcc)) {
+		return false
+	}
+
 	// Locator
 	if filter.Locator != "" && !(strings.HasPrefix(spot.SenderLocator, filter.Locator) || strings.HasPrefix(spot.ReceiverLocator, filter.Locator)) {
 		return false
 	}
+	if filter.SenderLocator != "" && !strings.HasPrefix(spot.SenderLocator, filter.SenderLocator) {
+		return false
+	}
+	if filter.ReceiverLocator != "" && !strings.HasPrefix(spot.ReceiverLocator, filter.ReceiverLocator) {
+		return false
+	}
 
 	// Callsign
 	if filter.Callsign != "" && !(strings.HasPrefix(spot.SenderCallsign, filter.Callsign) || strings.HasPrefix(spot.ReceiverCallsign, filter.Callsign)) {
 		return false
 	}
+	if filter.SenderCallsign != "" && !strings.HasPrefix(spot.SenderCallsign, filter.SenderCallsign) {
+		return false
+	}
+	if filter.ReceiverCallsign != "" && !strings.HasPrefix(spot.ReceiverCallsign, filter.ReceiverCallsign) {
+		return false
+	}
+
+	// Query, on top of all the above
+	if filter.expression != nil && !filter.expression.Eval(spot) {
+		return false
+	}
 
 	return true
 }
+
+// Terms describes the filter in short, for showing it on pages
+func (filter Filter) Terms() []string {
+	var terms []string
+	terms = append(terms, filter.Bands...)
+	terms = append(terms, filter.Modes...)
+	terms = append(terms, filter.Directions...)
+	terms = append(terms, filter.Continents...)
+	terms = append(terms, filter.Entities...)
+	if filter.Locator != "" {
+		terms = append(terms, filter.Locator)
+	}
+	if filter.Callsign != "" {
+		terms = append(terms, filter.Callsign)
+	}
+	for _, term := range [][2]string{
+		{"sender_locator", filter.SenderLocator},
+		{"sender_callsign", filter.SenderCallsign},
+		{"receiver_locator", filter.ReceiverLocator},
+		{"receiver_callsign", filter.ReceiverCallsign},
+	} {
+		if term[1] != "" {
+			terms = append(terms, term[0]+"="+term[1])
+		}
+	}
+	if filter.MinDistance != nil {
+		terms = append(terms, fmt.Sprintf("min_distance=%d", *filter.MinDistance))
+	}
+	if filter.MaxDistance != nil {
+		terms = append(terms, fmt.Sprintf("max_distance=%d", *filter.MaxDistance))
+	}
+	if filter.MinReport != nil {
+		terms = append(terms, fmt.Sprintf("min_report=%d", *filter.MinReport))
+	}
+	if filter.MaxReport != nil {
+		terms = append(terms, fmt.Sprintf("max_report=%d", *filter.MaxReport))
+	}
+	if filter.MinFrequency != nil {
+		terms = append(terms, fmt.Sprintf("min_freq=%g", *filter.MinFrequency))
+	}
+	if filter.MaxFrequency != nil {
+		terms = append(terms, fmt.Sprintf("max_freq=%g", *filter.MaxFrequency))
+	}
+	if filter.Since != 0 {
+		terms = append(terms, fmt.Sprintf("since=%d", filter.Since))
+	}
+	if filter.Until != 0 {
+		terms = append(terms, fmt.Sprintf("until=%d", filter.Until))
+	}
+	if filter.expression != nil {
+		terms = append(terms, filter.Query)
+	}
+	return terms
+}
+
+// Entities are given by name, in any case, or by their ADIF code
+func matchesEntity(entities []string, entity *DxccEntity) bool {
+	if entity == nil {
+		return false
+	}
+	for _, wanted := range entities {
+		if strings.EqualFold(wanted, entity.Name) || wanted == strconv.Itoa(entity.Adif) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,21 +2,64 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 )
 
+func pointer[T any](value T) *T {
+	return &value
+}
+
 func TestNewFilter(t *testing.T) {
 	type args struct {
 		config  Config
 		request *http.Request
 	}
+	config := Config{Bands: []string{"6m", "2m", "70cm"}, Countries: []int{224}}
 	tests := []struct {
 		name string
 		args args
 		want Filter
 	}{
-		// TODO: Add test cases.
+		{
+			name: "unfiltered",
+			args: args{config, httptest.NewRequest("GET", "/", nil)},
+			want: Filter{countries: []int{224}},
+		},
+		{
+			name: "bands and modes",
+			args: args{config, httptest.NewRequest("GET", "/?bands=2m,23cm,2m&modes=FT8,FT4", nil)},
+			want: Filter{Enabled: true, Bands: []string{"2m"}, Modes: []string{"FT8", "FT4"}, countries: []int{224}},
+		},
+		{
+			name: "ends",
+			args: args{config, httptest.NewRequest("GET", "/?sender_locator=KP20&receiver_callsign=SM&direction=sent,north", nil)},
+			want: Filter{Enabled: true, SenderLocator: "KP20", ReceiverCallsign: "SM", Directions: []string{"sent"}, countries: []int{224}},
+		},
+		{
+			name: "ranges",
+			args: args{config, httptest.NewRequest("GET", "/?min_distance=500&max_report=-10&min_freq=144.174&max_freq=far&since=1700000000&until=2023-11-14T23:00:00Z", nil)},
+			want: Filter{
+				Enabled:      true,
+				MinDistance:  pointer(int64(500)),
+				MaxReport:    pointer(-10),
+				MinFrequency: pointer(144.174),
+				Since:        1700000000,
+				Until:        1700002800,
+				countries:    []int{224},
+			},
+		},
+		{
+			name: "not finite",
+			args: args{config, httptest.NewRequest("GET", "/?min_freq=NaN&max_freq=Inf&min_report=-10", nil)},
+			want: Filter{Enabled: true, MinReport: pointer(-10), countries: []int{224}},
+		},
+		{
+			name: "DXCC",
+			args: args{config, httptest.NewRequest("GET", "/?continent=eu,XX&entity=Sweden,224", nil)},
+			want: Filter{Enabled: true, Continents: []string{"EU"}, Entities: []string{"Sweden", "224"}, countries: []int{224}},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -29,33 +72,106 @@ func TestNewFilter(t *testing.T) {
 
 func TestFilter_filter(t *testing.T) {
 	type fields struct {
-		Enabled  bool
-		Locator  string
-		Callsign string
-		Bands    []string
-		Modes    []string
+		Enabled          bool
+		Locator          string
+		Callsign         string
+		SenderLocator    string
+		SenderCallsign   string
+		ReceiverLocator  string
+		ReceiverCallsign string
+		Bands            []string
+		Modes            []string
+		Continents       []string
+		Entities         []string
+		Directions       []string
+		MinDistance      *int64
+		MaxDistance      *int64
+		MinReport        *int
+		MaxReport        *int
+		MinFrequency     *float64
+		MaxFrequency     *float64
+		Since            uint64
+		Until            uint64
 	}
 	type args struct {
 		spot Payload
 	}
+	spot := Payload{
+		Frequency:        144174500,
+		Mode:             "FT8",
+		Report:           -12,
+		Time:             1700000000,
+		Distance:         401,
+		SenderCallsign:   "OH2EWL",
+		SenderLocator:    "KP20le",
+		ReceiverCallsign: "SM5X",
+		ReceiverLocator:  "JO89",
+		SenderCountry:    224,
+		ReceiverCountry:  284,
+		Band:             "2m",
+		SenderDxcc:       &DxccEntity{Name: "Finland", Adif: 224, Continent: "EU"},
+		ReceiverDxcc:     &DxccEntity{Name: "Sweden", Adif: 284, Continent: "EU"},
+		located:          true,
+	}
 	tests := []struct {
 		name   string
 		fields fields
 		args   args
 		want   bool
 	}{
-		// TODO: Add test cases.
+		{"band", fields{Bands: []string{"2m", "70cm"}}, args{spot}, true},
+		{"other band", fields{Bands: []string{"70cm"}}, args{spot}, false},
+		{"mode", fields{Modes: []string{"FT4"}}, args{spot}, false},
+		{"locator of either end", fields{Locator: "JO8"}, args{spot}, true},
+		{"callsign of either end", fields{Callsign: "OH2"}, args{spot}, true},
+		{"sender locator", fields{SenderLocator: "KP20"}, args{spot}, true},
+		{"sender locator at the receiver", fields{SenderLocator: "JO89"}, args{spot}, false},
+		{"receiver callsign", fields{ReceiverCallsign: "SM5"}, args{spot}, true},
+		{"receiver callsign at the sender", fields{ReceiverCallsign: "OH2"}, args{spot}, false},
+		{"sent", fields{Directions: []string{DirectionSent}}, args{spot}, true},
+		{"received", fields{Directions: []string{DirectionReceived, DirectionLocal}}, args{spot}, false},
+		{"distance", fields{MinDistance: pointer(int64(401)), MaxDistance: pointer(int64(500))}, args{spot}, true},
+		{"too close", fields{MinDistance: pointer(int64(402))}, args{spot}, false},
+		{"no distance", fields{MaxDistance: pointer(int64(500))}, args{Payload{}}, false},
+		{"report", fields{MinReport: pointer(-15), MaxReport: pointer(-12)}, args{spot}, true},
+		{"too weak", fields{MinReport: pointer(-10)}, args{spot}, false},
+		{"frequency", fields{MinFrequency: pointer(144.174), MaxFrequency: pointer(144.175)}, args{spot}, true},
+		{"too high", fields{MaxFrequency: pointer(144.1744)}, args{spot}, false},
+		{"time", fields{Since: 1700000000, Until: 1700000000}, args{spot}, true},
+		{"too early", fields{Since: 1700000001}, args{spot}, false},
+		{"too late", fields{Until: 1699999999}, args{spot}, false},
+		{"continent", fields{Continents: []string{"EU"}}, args{spot}, true},
+		{"other continent", fields{Continents: []string{"NA"}}, args{spot}, false},
+		{"entity by name", fields{Entities: []string{"sweden"}}, args{spot}, true},
+		{"entity by code", fields{Entities: []string{"224"}}, args{spot}, true},
+		{"other entity", fields{Entities: []string{"Norway"}}, args{spot}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			filter := &Filter{
-				Enabled:  tt.fields.Enabled,
-				Locator:  tt.fields.Locator,
-				Callsign: tt.fields.Callsign,
-				Bands:    tt.fields.Bands,
-				Modes:    tt.fields.Modes,
+				Enabled:          tt.fields.Enabled,
+				Locator:          tt.fields.Locator,
+				Callsign:         tt.fields.Callsign,
+				SenderLocator:    tt.fields.SenderLocator,
+				SenderCallsign:   tt.fields.SenderCallsign,
+				ReceiverLocator:  tt.fields.ReceiverLocator,
+				ReceiverCallsign: tt.fields.ReceiverCallsign,
+				Bands:            tt.fields.Bands,
+				Modes:            tt.fields.Modes,
+				Continents:       tt.fields.Continents,
+				Entities:         tt.fields.Entities,
+				Directions:       tt.fields.Directions,
+				MinDistance:      tt.fields.MinDistance,
+				MaxDistance:      tt.fields.MaxDistance,
+				MinReport:        tt.fields.MinReport,
+				MaxReport:        tt.fields.MaxReport,
+				MinFrequency:     tt.fields.MinFrequency,
+				MaxFrequency:     tt.fields.MaxFrequency,
+				Since:            tt.fields.Since,
+				Until:            tt.fields.Until,
+				countries:        []int{224},
 			}
-			if got := filter.filter(tt.args.spot); got != tt.want {
+			if got := filter.filter(&tt.args.spot); got != tt.want {
 				t.Errorf("filter() = %v, want %v", got, tt.want)
 			}
 		})
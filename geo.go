@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/logocomune/maidenhead"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"math"
+)
+
+const (
+	GreatCircleStep        = 100000.0 // Meters between points along a path
+	GreatCircleMaxSegments = 64
+)
+
+// Resolve a Maidenhead locator to the center of its grid square
+func locatorPoint(locator string) (orb.Point, error) {
+	latitude, longitude, err := maidenhead.GridCenter(locator)
+	if err != nil {
+		return orb.Point{}, err
+	}
+	return orb.Point{longitude, latitude}, nil
+}
+
+// Work out the distance between the grid squares of the spot's ends, if
+// both of them can be placed
+func locate(spot *Payload) {
+	sender, err := locatorPoint(spot.SenderLocator)
+	if err != nil {
+		return
+	}
+	receiver, err := locatorPoint(spot.ReceiverLocator)
+	if err != nil {
+		return
+	}
+	spot.Distance = int64(geo.DistanceHaversine(sender, receiver) / 1000)
+	spot.located = true
+}
+
+// Follow the great circle from one point to another, splitting the path in
+// two where it crosses the antimeridian, as GeoJSON would have it
+func greatCircle(from, to orb.Point) orb.Geometry {
+	distance := geo.DistanceHaversine(from, to)
+	bearing := geo.Bearing(from, to)
+	segments := max(1, min(GreatCircleMaxSegments, int(distance/GreatCircleStep)))
+
+	lines := orb.MultiLineString{orb.LineString{from}}
+	previous := from
+	for i := 1; i <= segments; i++ {
+		point := to
+		if i < segments {
+			point = geo.PointAtBearingAndDistance(from, bearing, distance*float64(i)/float64(segments))
+			point[0] = math.Mod(point[0]+540, 360) - 180
+		}
+
+		if math.Abs(point[0]-previous[0]) > 180 {
+			edge := math.Copysign(180, previous[0])
+			unwrapped := point[0] + 2*edge
+			latitude := previous[1] + (edge-previous[0])/(unwrapped-previous[0])*(point[1]-previous[1])
+			lines[len(lines)-1] = append(lines[len(lines)-1], orb.Point{edge, latitude})
+			lines = append(lines, orb.LineString{{-edge, latitude}})
+		}
+
+		lines[len(lines)-1] = append(lines[len(lines)-1], point)
+		previous = point
+	}
+
+	if len(lines) == 1 {
+		return lines[0]
+	}
+	return lines
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/paulmach/orb"
+	"testing"
+)
+
+func TestGreatCircle(t *testing.T) {
+	tests := []struct {
+		name  string
+		from  orb.Point
+		to    orb.Point
+		lines int
+	}{
+		{"short", orb.Point{25, 60}, orb.Point{18, 59}, 1},
+		{"long", orb.Point{25, 60}, orb.Point{-75, 40}, 1},
+		{"antimeridian", orb.Point{170, 50}, orb.Point{-170, 50}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines orb.MultiLineString
+			switch got := greatCircle(tt.from, tt.to).(type) {
+			case orb.LineString:
+				lines = orb.MultiLineString{got}
+			case orb.MultiLineString:
+				lines = got
+			}
+			if len(lines) != tt.lines {
+				t.Fatalf("greatCircle() = %v, want %d lines", lines, tt.lines)
+			}
+			first, last := lines[0][0], lines[len(lines)-1][len(lines[len(lines)-1])-1]
+			if first != tt.from || last != tt.to {
+				t.Errorf("greatCircle() goes from %v to %v, want %v to %v", first, last, tt.from, tt.to)
+			}
+			for _, line := range lines {
+				for i := 1; i < len(line); i++ {
+					if d := line[i][0] - line[i-1][0]; d > 180 || d < -180 {
+						t.Errorf("greatCircle() jumps from %v to %v", line[i-1], line[i])
+					}
+				}
+			}
+		})
+	}
+}
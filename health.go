@@ -0,0 +1,186 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	mqttConnected  atomic.Bool
+	mqttSubscribed atomic.Bool
+)
+
+var (
+	connected_metric      prometheus.Gauge
+	reconnects_metric     prometheus.Counter
+	messages_metric       *prometheus.CounterVec
+	last_message_metric   *prometheus.GaugeVec
+	unmarshal_fail_metric prometheus.Counter
+	dropped_metric        *prometheus.CounterVec
+	webhooks_metric       *prometheus.CounterVec
+)
+
+func SetupHealthMetrics() {
+	connected_metric = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "mqtt",
+		Name:      "connected",
+		Help:      "Whether the MQTT client is connected to a broker",
+	})
+
+	reconnects_metric = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "mqtt",
+		Name:      "reconnects_total",
+		Help:      "Attempts to reconnect to a broker after losing the connection",
+	})
+
+	messages_metric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "mqtt",
+		Name:      "messages_total",
+		Help:      "Messages received, by the subscribed topic pattern they matched",
+	}, []string{"topic"})
+
+	last_message_metric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "mqtt",
+		Name:      "last_message_timestamp_seconds",
+		Help:      "When a message was last received, by the subscribed topic pattern it matched",
+	}, []string{"topic"})
+
+	unmarshal_fail_metric = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "mqtt",
+		Name:      "unmarshal_failures_total",
+		Help:      "Messages whose payload could not be unmarshalled",
+	})
+
+	dropped_metric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "dropped_total",
+		Help:      "Spots counted, but dropped on the way to the spotlog for it falling behind",
+	}, []string{"policy"})
+
+	webhooks_metric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "webhooks_total",
+		Help:      "Events posted to webhooks, by kind of event and whether the receiver took them",
+	}, []string{"kind", "result"})
+}
+
+// Keep an eye on how far Spotlog is lagging behind Subscribe
+func MonitorSpots(spots chan *Payload) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "queue_length",
+		Help:      "Spots waiting to be taken in by the spotlog",
+	}, func() float64 {
+		return float64(len(spots))
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "queue_capacity",
+		Help:      "Spots that fit in the queue to the spotlog",
+	}, func() float64 {
+		return float64(cap(spots))
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "retained",
+		Help:      "Spots retained in the spotlog",
+	}, func() float64 {
+		return float64(spotIndex.Len())
+	})
+
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "late_total",
+		Help:      "Spots that reached the spotlog later than its reorder window",
+	}, func() float64 {
+		return float64(spotIndex.Late())
+	})
+}
+
+func setConnected(connected bool) {
+	mqttConnected.Store(connected)
+	if !connected {
+		mqttSubscribed.Store(false)
+	}
+	if connected {
+		connected_metric.Set(1)
+	} else {
+		connected_metric.Set(0)
+	}
+}
+
+// Count a message against the first subscribed pattern it matches, as the
+// topics themselves have callsigns and locators in them
+func recordMessage(topics []string, topic string) {
+	for _, pattern := range topics {
+		if topicMatches(pattern, topic) {
+			messages_metric.WithLabelValues(pattern).Inc()
+			last_message_metric.WithLabelValues(pattern).Set(float64(time.Now().UnixMilli()) / 1000)
+			return
+		}
+	}
+}
+
+func forgetTopics(topics []string) {
+	for _, topic := range topics {
+		messages_metric.DeleteLabelValues(topic)
+		last_message_metric.DeleteLabelValues(topic)
+	}
+}
+
+// MQTT topic matching, with + for any one level and # for any remaining ones
+func topicMatches(pattern string, topic string) bool {
+	patternLevels := strings.Split(pattern, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range patternLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(patternLevels) == len(topicLevels)
+}
+
+func healthzHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(writer, "ok\n")
+}
+
+// Ready once connected to a broker and subscribed to the topics, or replaying
+func readyzHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case replaying.Load():
+		io.WriteString(writer, "ok\n")
+	case !mqttConnected.Load():
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(writer, "not connected\n")
+	case !mqttSubscribed.Load():
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(writer, "not subscribed\n")
+	default:
+		io.WriteString(writer, "ok\n")
+	}
+}
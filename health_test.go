@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"pskr/filter/v2/2m/+/+/+/+/+/224/+", "pskr/filter/v2/2m/FT8/OH2EWL/SM5X/KP20/JO89/224/284", true},
+		{"pskr/filter/v2/2m/+/+/+/+/+/224/+", "pskr/filter/v2/2m/FT8/SM5X/OH2EWL/JO89/KP20/284/224", false},
+		{"pskr/filter/v2/2m/+/+/+/+/+/+/224", "pskr/filter/v2/2m/FT8/SM5X/OH2EWL/JO89/KP20/284/224", true},
+		{"pskr/filter/v2/6m/+/+/+/+/+/224/+", "pskr/filter/v2/2m/FT8/OH2EWL/SM5X/KP20/JO89/224/284", false},
+		{"pskr/filter/v2/+/+/+/+/+/+/224/+", "pskr/filter/v2/2m/FT8/OH2EWL/SM5X/KP20/JO89/224", false},
+		{"pskr/filter/#", "pskr/filter/v2/2m/FT8/OH2EWL/SM5X/KP20/JO89/224/284", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.topic, func(t *testing.T) {
+			if got := topicMatches(tt.pattern, tt.topic); got != tt.want {
+				t.Errorf("topicMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
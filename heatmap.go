@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/logocomune/maidenhead"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultHeatmapPrecision = maidenhead.SquarePrecision
+	DefaultHeatmapWindow    = time.Hour
+	GeojsonType             = "application/geo+json"
+)
+
+// Field, square, and subsquare, e.g. KP, KP20, and KP20le
+var HeatmapPrecisions = []int{maidenhead.FieldPrecision, maidenhead.SquarePrecision, maidenhead.SubSquarePrecision}
+
+type HeatmapSquare struct {
+	Locator     string `json:"locator"`
+	Count       int    `json:"count"`
+	Senders     int    `json:"senders"`
+	Receivers   int    `json:"receivers"`
+	BestReport  int    `json:"best_report"`
+	MaxDistance int64  `json:"max_distance"`
+}
+
+type Heatmap struct {
+	Precision int              `json:"precision"`
+	Window    string           `json:"window"`
+	Squares   []*HeatmapSquare `json:"squares"`
+}
+
+// Truncate a locator to the given precision, in its customary letter case,
+// or give up if it doesn't go that far. Fields go from A to R, squares from 0
+// to 9, and subsquares from a to x, which the maidenhead package doesn't
+// check for.
+func truncateLocator(locator string, precision int) (string, bool) {
+	if len(locator) < precision {
+		return "", false
+	}
+	locator = strings.ToUpper(locator[:min(precision, maidenhead.SquarePrecision)]) + strings.ToLower(locator[min(precision, maidenhead.SquarePrecision):precision])
+	for i, c := range []byte(locator) {
+		switch {
+		case i < maidenhead.FieldPrecision && (c < 'A' || c > 'R'):
+			return "", false
+		case i >= maidenhead.FieldPrecision && i < maidenhead.SquarePrecision && (c < '0' || c > '9'):
+			return "", false
+		case i >= maidenhead.SquarePrecision && (c < 'a' || c > 'x'):
+			return "", false
+		}
+	}
+	return locator, true
+}
+
+func heatmapHandler(getConfig func() Config) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		config := getConfig()
+		log.Debug().Str("query", request.URL.RawQuery).Msg("Serving heatmap")
+
+		query := request.URL.Query()
+
+		precision := DefaultHeatmapPrecision
+		if value := query.Get("precision"); value != "" {
+			var err error
+			if precision, err = strconv.Atoi(value); err != nil || !slices.Contains(HeatmapPrecisions, precision) {
+				http.Error(writer, fmt.Sprintf("precision: expecting one of %v: %q", HeatmapPrecisions, value), http.StatusBadRequest)
+				return
+			}
+		}
+
+		window := DefaultHeatmapWindow
+		if value := query.Get("window"); value != "" {
+			var err error
+			if window, err = time.ParseDuration(value); err != nil || window <= 0 {
+				http.Error(writer, fmt.Sprintf("window: not a positive duration: %q", value), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// A single band reads better here, but the filter's list works too
+		if band := query.Get("band"); band != "" && query.Get("bands") == "" {
+			query.Set("bands", band)
+		}
+		filter := newFilter(config, query)
+		if err := filter.Err(); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		filter.Since = max(filter.Since, uint64(time.Now().UTC().Add(-window).Unix()))
+		heatmap := Heatmap{
+			Precision: precision,
+			Window:    formatWindow(window),
+			Squares:   heatmapSquares(spotIndex.Find(&filter, 0), precision),
+		}
+
+		if query.Get("format") == "geojson" || strings.Contains(request.Header.Get("Accept"), GeojsonType) {
+			collection := geojson.NewFeatureCollection()
+			for _, square := range heatmap.Squares {
+				corners, _ := maidenhead.Square(square.Locator)
+				feature := geojson.NewFeature(orb.Polygon{orb.Ring{
+					{corners.BottomLeft.Lng, corners.BottomLeft.Lat},
+					{corners.BottomRight.Lng, corners.BottomRight.Lat},
+					{corners.TopRight.Lng, corners.TopRight.Lat},
+					{corners.TopLeft.Lng, corners.TopLeft.Lat},
+					{corners.BottomLeft.Lng, corners.BottomLeft.Lat},
+				}})
+				feature.ID = square.Locator
+				feature.Properties = geojson.Properties{
+					"locator":      square.Locator,
+					"count":        square.Count,
+					"senders":      square.Senders,
+					"receivers":    square.Receivers,
+					"best_report":  square.BestReport,
+					"max_distance": square.MaxDistance,
+				}
+				collection.Append(feature)
+			}
+			writer.Header().Set("Content-Type", GeojsonType)
+			if err := json.NewEncoder(writer).Encode(collection); err != nil {
+				log.Debug().Err(err).Msg("Could not write heatmap")
+			}
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(writer).Encode(heatmap); err != nil {
+			log.Debug().Err(err).Msg("Could not write heatmap")
+		}
+	}
+}
+
+// Count the spots into the squares of either end, busiest first
+func heatmapSquares(spots []*Payload, precision int) []*HeatmapSquare {
+	squares := make(map[string]*HeatmapSquare)
+	observe := func(locator string, spot *Payload) *HeatmapSquare {
+		square, ok := squares[locator]
+		if !ok {
+			square = &HeatmapSquare{Locator: locator, BestReport: spot.Report}
+			squares[locator] = square
+		}
+		square.Count += 1
+		square.BestReport = max(square.BestReport, spot.Report)
+		square.MaxDistance = max(square.MaxDistance, spot.Distance)
+		return square
+	}
+
+	for _, spot := range spots {
+		sender, senderOk := truncateLocator(spot.SenderLocator, precision)
+		receiver, receiverOk := truncateLocator(spot.ReceiverLocator, precision)
+		if senderOk {
+			observe(sender, spot).Senders += 1
+		}
+		if receiverOk {
+			// Both ends in the same square still make just one spot
+			if !senderOk || receiver != sender {
+				observe(receiver, spot)
+			}
+			squares[receiver].Receivers += 1
+		}
+	}
+
+	list := make([]*HeatmapSquare, 0, len(squares))
+	for _, square := range squares {
+		list = append(list, square)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Locator < list[j].Locator
+	})
+
+	return list
+}
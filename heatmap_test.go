@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTruncateLocator(t *testing.T) {
+	tests := []struct {
+		locator   string
+		precision int
+		want      string
+		ok        bool
+	}{
+		{"KP20le", 2, "KP", true},
+		{"KP20le", 4, "KP20", true},
+		{"kp20LE", 6, "KP20le", true},
+		{"KP20le55", 6, "KP20le", true},
+		{"KP20l", 4, "KP20", true},
+		{"KP20l", 6, "", false},
+		{"KP2", 2, "KP", true},
+		{"KP2", 4, "", false},
+		{"kp", 2, "KP", true},
+		{"K", 2, "", false},
+		{"", 2, "", false},
+		{"ZZ99", 2, "", false},
+		{"KP2X", 4, "", false},
+		{"KP20zz", 4, "KP20", true},
+		{"KP20zz", 6, "", false},
+		{"KP20l5", 6, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.locator, func(t *testing.T) {
+			got, ok := truncateLocator(tt.locator, tt.precision)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("truncateLocator(%q, %d) = %q, %v, want %q, %v", tt.locator, tt.precision, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestHeatmapSquares(t *testing.T) {
+	spots := []*Payload{
+		{SenderLocator: "KP20le", ReceiverLocator: "KP20lf", Report: -5, Distance: 10},
+		{SenderLocator: "KP20le", ReceiverLocator: "JO89ab", Report: 3, Distance: 400},
+		{ReceiverLocator: "jo89ac", Report: -10},
+		{SenderLocator: "KP2", ReceiverLocator: "ZZ99"},
+	}
+	tests := []struct {
+		name      string
+		precision int
+		want      []HeatmapSquare
+	}{
+		{"field", 2, []HeatmapSquare{
+			{Locator: "KP", Count: 3, Senders: 3, Receivers: 1, BestReport: 3, MaxDistance: 400},
+			{Locator: "JO", Count: 2, Receivers: 2, BestReport: 3, MaxDistance: 400},
+		}},
+		{"square", 4, []HeatmapSquare{
+			{Locator: "JO89", Count: 2, Receivers: 2, BestReport: 3, MaxDistance: 400},
+			{Locator: "KP20", Count: 2, Senders: 2, Receivers: 1, BestReport: 3, MaxDistance: 400},
+		}},
+		{"subsquare", 6, []HeatmapSquare{
+			{Locator: "KP20le", Count: 2, Senders: 2, BestReport: 3, MaxDistance: 400},
+			{Locator: "JO89ab", Count: 1, Receivers: 1, BestReport: 3, MaxDistance: 400},
+			{Locator: "JO89ac", Count: 1, Receivers: 1, BestReport: -10},
+			{Locator: "KP20lf", Count: 1, Receivers: 1, BestReport: -5, MaxDistance: 10},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []HeatmapSquare
+			for _, square := range heatmapSquares(spots, tt.precision) {
+				got = append(got, *square)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("heatmapSquares() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"iter"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Spots arrive out of order by about this much at most; later ones are
+	// still placed in order, just with more shuffling, and counted
+	SpotIndexReorderWindow = time.Minute * 5
+
+	// Callsigns are indexed by this many leading characters
+	CallsignIndexLength = 2
+)
+
+// SpotIndex holds the retained spots in time order, sequence number breaking
+// ties, along with the same spots by band, mode, and callsign prefix of either
+// end, so that time ranges are found by binary search, and narrower filters
+// by looking at a fraction of the spots. Pruning slices the old ones off the
+// front, leaving appends to let go of the storage underneath.
+type SpotIndex struct {
+	lock      sync.RWMutex
+	spots     []*Payload
+	bands     map[string][]*Payload
+	modes     map[string][]*Payload
+	callsigns map[string][]*Payload
+	late      uint64
+}
+
+var spotIndex = NewSpotIndex()
+
+func NewSpotIndex() *SpotIndex {
+	return &SpotIndex{
+		bands:     make(map[string][]*Payload),
+		modes:     make(map[string][]*Payload),
+		callsigns: make(map[string][]*Payload),
+	}
+}
+
+func (index *SpotIndex) Insert(spot *Payload) {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	if len(index.spots) > 0 && spot.Time+uint64(SpotIndexReorderWindow.Seconds()) < index.spots[len(index.spots)-1].Time {
+		index.late += 1
+	}
+
+	index.spots = insertByTime(index.spots, spot)
+	if spot.Band != "" {
+		index.bands[spot.Band] = insertByTime(index.bands[spot.Band], spot)
+	}
+	if spot.Mode != "" {
+		index.modes[spot.Mode] = insertByTime(index.modes[spot.Mode], spot)
+	}
+	sender, senderOk := callsignKey(spot.SenderCallsign)
+	if senderOk {
+		index.callsigns[sender] = insertByTime(index.callsigns[sender], spot)
+	}
+	if receiver, ok := callsignKey(spot.ReceiverCallsign); ok && !(senderOk && receiver == sender) {
+		index.callsigns[receiver] = insertByTime(index.callsigns[receiver], spot)
+	}
+}
+
+// Prune lets go of spots older than cutoff, and tells how many are left
+func (index *SpotIndex) Prune(cutoff uint64) int {
+	index.lock.Lock()
+	defer index.lock.Unlock()
+
+	index.spots = pruneByTime(index.spots, cutoff)
+	for _, spots := range []map[string][]*Payload{index.bands, index.modes, index.callsigns} {
+		for key, list := range spots {
+			if list = pruneByTime(list, cutoff); len(list) == 0 {
+				delete(spots, key)
+			} else {
+				spots[key] = list
+			}
+		}
+	}
+
+	return len(index.spots)
+}
+
+func (index *SpotIndex) Len() int {
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+
+	return len(index.spots)
+}
+
+// Late tells how many spots have arrived later than the reorder window
+func (index *SpotIndex) Late() uint64 {
+	index.lock.RLock()
+	defer index.lock.RUnlock()
+
+	return index.late
+}
+
+// Find returns the spots between the filter's since and until in time order,
+// only those the filter lets through when enabled, and only the newest limit
+// of them unless limit is 0
+func (index *SpotIndex) Find(filter *Filter, limit int) []*Payload {
+	var found []*Payload
+	for spot := range index.Newest(filter) {
+		if limit != 0 && len(found) == limit {
+			break
+		}
+		found = append(found, spot)
+	}
+
+	slices.Reverse(found)
+	return found
+}
+
+// Newest goes through the spots Find would return, newest first, holding
+// the index for reading all the while, so there's no dawdling in the loop
+func (index *SpotIndex) Newest(filter *Filter) iter.Seq[*Payload] {
+	return index.walk(filter, true)
+}
+
+// Oldest goes through the same spots as Newest, oldest first
+func (index *SpotIndex) Oldest(filter *Filter) iter.Seq[*Payload] {
+	return index.walk(filter, false)
+}
+
+func (index *SpotIndex) walk(filter *Filter, newestFirst bool) iter.Seq[*Payload] {
+	return func(yield func(*Payload) bool) {
+		index.lock.RLock()
+		defer index.lock.RUnlock()
+
+		// Lists of spots in time order, none in more than one, each walked
+		// from one end to the other
+		lists := index.candidates(filter)
+		cursors := make([]int, len(lists))
+		step := 1
+		if newestFirst {
+			for i, list := range lists {
+				cursors[i] = len(list) - 1
+			}
+			step = -1
+		}
+
+		for {
+			next := -1
+			for i, list := range lists {
+				if cursors[i] < 0 || cursors[i] >= len(list) {
+					continue
+				}
+				if next < 0 {
+					next = i
+					continue
+				}
+				// Ties between lists go by sequence number, as they do within
+				// each, so that the same spots come out in the same order
+				// whichever lists hold them
+				candidate, current := list[cursors[i]], lists[next][cursors[next]]
+				if newestFirst && after(candidate, current) || !newestFirst && after(current, candidate) {
+					next = i
+				}
+			}
+			if next < 0 {
+				return
+			}
+			spot := lists[next][cursors[next]]
+			cursors[next] += step
+
+			if filter.Enabled && !filter.filter(spot) {
+				continue
+			}
+			if !yield(spot) {
+				return
+			}
+		}
+	}
+}
+
+// Pick whichever index holds the fewest spots in the time range, the filter
+// doing the rest
+func (index *SpotIndex) candidates(filter *Filter) [][]*Payload {
+	best := [][]*Payload{timeRange(index.spots, filter.Since, filter.Until)}
+	if !filter.Enabled {
+		return best
+	}
+
+	options := [][][]*Payload{
+		lookup(index.bands, filter.Bands, filter.Since, filter.Until),
+		lookup(index.modes, filter.Modes, filter.Since, filter.Until),
+	}
+	for _, callsign := range []string{filter.Callsign, filter.SenderCallsign, filter.ReceiverCallsign} {
+		if key, ok := callsignKey(callsign); ok {
+			options = append(options, lookup(index.callsigns, []string{key}, filter.Since, filter.Until))
+		}
+	}
+
+	count := func(lists [][]*Payload) int {
+		total := 0
+		for _, list := range lists {
+			total += len(list)
+		}
+		return total
+	}
+	for _, option := range options {
+		if option != nil && count(option) < count(best) {
+			best = option
+		}
+	}
+
+	return best
+}
+
+// Lists for the given keys, or nil when there are no keys to go by
+func lookup(spots map[string][]*Payload, keys []string, since uint64, until uint64) [][]*Payload {
+	if keys == nil {
+		return nil
+	}
+	lists := make([][]*Payload, 0, len(keys))
+	for _, key := range keys {
+		if list := timeRange(spots[key], since, until); len(list) > 0 {
+			lists = append(lists, list)
+		}
+	}
+	return lists
+}
+
+func callsignKey(callsign string) (string, bool) {
+	if len(callsign) < CallsignIndexLength {
+		return "", false
+	}
+	return callsign[:CallsignIndexLength], true
+}
+
+// Spots are mostly the newest so far, so look at the end before searching
+func insertByTime(spots []*Payload, spot *Payload) []*Payload {
+	if len(spots) == 0 || !after(spots[len(spots)-1], spot) {
+		return append(spots, spot)
+	}
+
+	i := sort.Search(len(spots), func(i int) bool { return after(spots[i], spot) })
+	spots = append(spots, nil)
+	copy(spots[i+1:], spots[i:])
+	spots[i] = spot
+	return spots
+}
+
+func after(spot, other *Payload) bool {
+	return spot.Time > other.Time || spot.Time == other.Time && spot.SequenceNumber > other.SequenceNumber
+}
+
+// Clear the pointers left behind, so that the spots can go even before the
+// storage does
+func pruneByTime(spots []*Payload, cutoff uint64) []*Payload {
+	i := sort.Search(len(spots), func(i int) bool { return spots[i].Time >= cutoff })
+	clear(spots[:i])
+	return spots[i:]
+}
+
+// Spots between since and until, inclusive, either being 0 for no bound
+func timeRange(spots []*Payload, since uint64, until uint64) []*Payload {
+	start := sort.Search(len(spots), func(i int) bool { return spots[i].Time >= since })
+	end := len(spots)
+	if until != 0 {
+		end = sort.Search(len(spots), func(i int) bool { return spots[i].Time > until })
+	}
+	return spots[start:max(start, end)]
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rs/zerolog"
+	"math/rand"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"text/template"
+)
+
+func sequences(spots []*Payload) []uint64 {
+	var numbers []uint64
+	for _, spot := range spots {
+		numbers = append(numbers, spot.SequenceNumber)
+	}
+	return numbers
+}
+
+func TestSpotIndex(t *testing.T) {
+	index := NewSpotIndex()
+	for _, spot := range []*Payload{
+		{SequenceNumber: 1, Time: 1000, Band: "2m", Mode: "FT8", SenderCallsign: "OH2XYZ", ReceiverCallsign: "SM5ABC"},
+		{SequenceNumber: 2, Time: 1010, Band: "70cm", Mode: "FT8", SenderCallsign: "SM5ABC", ReceiverCallsign: "SM6XYZ"},
+		{SequenceNumber: 3, Time: 1005, Band: "2m", Mode: "MSK144", SenderCallsign: "OH6ABC", ReceiverCallsign: "OH2XYZ"},
+		{SequenceNumber: 4, Time: 1010, Band: "6m", Mode: "FT8", SenderCallsign: "ES1AB", ReceiverCallsign: "OH2ABC"},
+		{SequenceNumber: 5, Time: 600, Band: "2m", Mode: "FT8", SenderCallsign: "OH2ZZZ", ReceiverCallsign: "ES1AB"},
+	} {
+		index.Insert(spot)
+	}
+
+	if got := sequences(index.Find(&Filter{}, 0)); !slices.Equal(got, []uint64{5, 1, 3, 2, 4}) {
+		t.Errorf("Find() = %v, want time order, ties in sequence order", got)
+	}
+	if got := sequences(slices.Collect(index.Oldest(&Filter{}))); !slices.Equal(got, []uint64{5, 1, 3, 2, 4}) {
+		t.Errorf("Oldest() = %v, want the same as Find()", got)
+	}
+	if got := index.Late(); got != 1 {
+		t.Errorf("Late() = %v, want 1", got)
+	}
+
+	config := Config{Bands: []string{"6m", "2m", "70cm"}}
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []uint64
+	}{
+		{"band", "bands=2m", 0, []uint64{5, 1, 3}},
+		{"bands", "bands=2m,6m", 0, []uint64{5, 1, 3, 4}},
+		{"mode and range", "modes=FT8&since=1000&until=1010", 0, []uint64{1, 2, 4}},
+		{"callsign either end", "callsign=OH2", 0, []uint64{5, 1, 3, 4}},
+		{"callsign too short to index", "callsign=O", 0, []uint64{5, 1, 3, 4}},
+		{"receiver callsign", "receiver_callsign=OH2X", 0, []uint64{3}},
+		{"newest", "modes=FT8", 2, []uint64{2, 4}},
+		{"absent band", "bands=70cm&modes=MSK144", 0, nil},
+		{"query", "q=sc ~ \"*AB*\"", 0, []uint64{3, 2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			filter := newFilter(config, query)
+			if got := sequences(index.Find(&filter, tt.limit)); !slices.Equal(got, tt.want) {
+				t.Errorf("Find() = %v, want %v", got, tt.want)
+			}
+			newest := sequences(slices.Collect(index.Newest(&filter)))
+			slices.Reverse(newest)
+			if tt.limit == 0 && !slices.Equal(newest, tt.want) {
+				t.Errorf("Newest() = %v, want %v the other way round", newest, tt.want)
+			}
+		})
+	}
+
+	if got := index.Prune(1005); got != 3 {
+		t.Errorf("Prune() = %v, want 3 left", got)
+	}
+	if got := sequences(index.Find(&Filter{}, 0)); !slices.Equal(got, []uint64{3, 2, 4}) {
+		t.Errorf("Find() after Prune() = %v, want 3, 2, 4", got)
+	}
+	if _, ok := index.bands["2m"]; !ok {
+		t.Errorf("Prune() removed 2m, which has spot 3 left")
+	}
+	if _, ok := index.callsigns["ES"]; !ok {
+		t.Errorf("Prune() removed ES, which has spot 4 left")
+	}
+	if _, ok := index.modes["MSK144"]; !ok {
+		t.Errorf("Prune() removed MSK144, which has spot 3 left")
+	}
+	index.Prune(1006)
+	if _, ok := index.modes["MSK144"]; ok {
+		t.Errorf("Prune() kept MSK144, which has no spots left")
+	}
+}
+
+func TestSpotIndexSameSecond(t *testing.T) {
+	index := NewSpotIndex()
+	for _, spot := range []*Payload{
+		{SequenceNumber: 5, Time: 100, Band: "6m", Mode: "FT8"},
+		{SequenceNumber: 3, Time: 100, Band: "6m", Mode: "FT8"},
+		{SequenceNumber: 4, Time: 100, Band: "70cm", Mode: "FT8"},
+		{SequenceNumber: 9, Time: 100, Band: "2m", Mode: "FT4"},
+	} {
+		index.Insert(spot)
+	}
+	for number := range 10 {
+		index.Insert(&Payload{SequenceNumber: uint64(10 + number), Time: 50, Band: "6m", Mode: "FT4"})
+	}
+
+	// Which lists are read depends on the filter, not the order
+	config := Config{Bands: []string{"6m", "2m", "70cm"}}
+	tests := []struct {
+		name  string
+		query string
+		want  []uint64
+	}{
+		{"all", "", []uint64{10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 3, 4, 5, 9}},
+		{"bands", "bands=6m,70cm", []uint64{10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 3, 4, 5}},
+		{"bands and mode", "bands=6m,70cm&modes=FT8", []uint64{3, 4, 5}},
+		{"bands since", "bands=6m,70cm&since=100", []uint64{3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			filter := newFilter(config, query)
+			oldest := sequences(slices.Collect(index.Oldest(&filter)))
+			if !slices.Equal(oldest, tt.want) {
+				t.Errorf("Oldest() = %v, want %v", oldest, tt.want)
+			}
+			newest := sequences(slices.Collect(index.Newest(&filter)))
+			slices.Reverse(newest)
+			if !slices.Equal(newest, oldest) {
+				t.Errorf("Newest() = %v, want %v the other way round", newest, oldest)
+			}
+		})
+	}
+}
+
+// A few million spots over the default 60 hours of retention, a few per
+// second, some of them out of order
+const benchmarkSpots = 2000000
+
+var (
+	benchmarkIndex     *SpotIndex
+	benchmarkIndexOnce sync.Once
+)
+
+func benchmarkSpot(random *rand.Rand, number int) *Payload {
+	bands := []string{"6m", "4m", "2m", "2m", "2m", "70cm", "23cm"}
+	modes := []string{"FT8", "FT8", "FT8", "FT4", "MSK144", "Q65"}
+	prefixes := []string{"OH", "SM", "LA", "OZ", "ES", "DL", "G", "PA", "SP", "YL"}
+	callsign := func() string {
+		return fmt.Sprintf("%s%d%c%c", prefixes[random.Intn(len(prefixes))], random.Intn(10), 'A'+random.Intn(26), 'A'+random.Intn(26))
+	}
+	return &Payload{
+		SequenceNumber:   uint64(number),
+		Time:             uint64(1700000000 + number*216000/benchmarkSpots + max(0, random.Intn(120)-100)),
+		Band:             bands[random.Intn(len(bands))],
+		Mode:             modes[random.Intn(len(modes))],
+		Report:           random.Intn(40) - 25,
+		SenderCallsign:   callsign(),
+		ReceiverCallsign: callsign(),
+	}
+}
+
+func setupBenchmarkIndex(b *testing.B) *SpotIndex {
+	benchmarkIndexOnce.Do(func() {
+		random := rand.New(rand.NewSource(1))
+		benchmarkIndex = NewSpotIndex()
+		for number := range benchmarkSpots {
+			benchmarkIndex.Insert(benchmarkSpot(random, number))
+		}
+	})
+	b.ResetTimer()
+	return benchmarkIndex
+}
+
+func BenchmarkSpotIndexInsert(b *testing.B) {
+	random := rand.New(rand.NewSource(1))
+	spots := make([]*Payload, b.N)
+	for number := range spots {
+		spots[number] = benchmarkSpot(random, number)
+	}
+	index := NewSpotIndex()
+	b.ResetTimer()
+
+	for _, spot := range spots {
+		index.Insert(spot)
+	}
+}
+
+func BenchmarkSpotIndexFind(b *testing.B) {
+	index := setupBenchmarkIndex(b)
+	config := Config{Bands: []string{"6m", "4m", "2m", "70cm", "23cm"}}
+
+	for _, benchmark := range []struct {
+		name  string
+		query string
+		limit int
+	}{
+		{"page", "", 1000},
+		{"band page", "bands=23cm", 1000},
+		{"hour", "since=1700100000&until=1700103600", 0},
+		{"callsign", "callsign=OH2", 0},
+		{"band and mode", "bands=6m&modes=Q65", 0},
+		{"report", "min_report=10", 1000},
+		{"query", "q=band = 4m and report > 5", 1000},
+	} {
+		query, _ := url.ParseQuery(benchmark.query)
+		filter := newFilter(config, query)
+		b.Run(benchmark.name, func(b *testing.B) {
+			for range b.N {
+				index.Find(&filter, benchmark.limit)
+			}
+		})
+	}
+
+	// The API, pages of which scripts go through, without logging each one
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	defer zerolog.SetGlobalLevel(level)
+	previous := spotIndex
+	spotIndex = index
+	defer func() { spotIndex = previous }()
+	handler := spotsApiHandler(func() Config { return config })
+	for _, benchmark := range []struct {
+		name  string
+		query string
+	}{
+		{"api first page", "limit=1000"},
+		{"api middle page", "limit=1000&cursor=1700100000-1000000"},
+		{"api newest page", "limit=1000&order=newest"},
+		{"api band page", "bands=23cm&limit=1000&cursor=1700100000-1000000"},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			for range b.N {
+				handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/spots?"+benchmark.query, nil))
+			}
+		})
+	}
+
+	// The page, rendered in full as it would be by a browser
+	pageTemplate = template.Must(template.New("page").Parse(pageHtml))
+	tablerowTemplate = template.Must(template.New("tablerow").Parse(tablerowHtml))
+	page := pageHandler(func() Config { return config })
+	for _, benchmark := range []struct {
+		name  string
+		query string
+	}{
+		{"page first", ""},
+		{"page older", "before=1700100000-1000000"},
+		{"page filtered", "bands=2m&modes=FT8&callsign=OH&min_report=-10"},
+	} {
+		b.Run(benchmark.name, func(b *testing.B) {
+			for range b.N {
+				page(httptest.NewRecorder(), httptest.NewRequest("GET", "/?"+benchmark.query, nil))
+			}
+		})
+	}
+}
+
+func BenchmarkSpotIndexPrune(b *testing.B) {
+	index := setupBenchmarkIndex(b)
+
+	// Pruning nothing, as every interval but the first after startup
+	for range b.N {
+		index.Prune(1700000000)
+	}
+}
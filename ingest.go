@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// Ingester turns raw PSK Reporter payloads into spots, counts them, and hands
+// them on to the spotlog, whether they come from MQTT or from a recording
+type Ingester struct {
+	spots        chan *Payload
+	deduplicator *Deduplicator
+	recorder     *Recorder
+	lock         sync.RWMutex
+	stopped      bool
+}
+
+// NewIngester feeds the spots channel, and if given a recorder, also tees the
+// raw messages to it
+func NewIngester(ctx context.Context, spots chan *Payload, recorder *Recorder) *Ingester {
+	ingester := &Ingester{
+		spots:        spots,
+		deduplicator: NewDeduplicator(DuplicateRetention),
+		recorder:     recorder,
+	}
+	go ingester.deduplicator.PruneEvery(ctx, DuplicatePruneInterval)
+
+	return ingester
+}
+
+func (ingester *Ingester) Ingest(topic string, raw []byte) {
+	ingester.IngestShifted(topic, raw, 0)
+}
+
+// IngestShifted ingests a message with the spot's time moved by shift, for
+// replaying a recording as if it were coming in now
+func (ingester *Ingester) IngestShifted(topic string, raw []byte, shift time.Duration) {
+	// Messages may still trickle in while stopping, but the spots channel is
+	// not to be sent to after that
+	ingester.lock.RLock()
+	defer ingester.lock.RUnlock()
+	if ingester.stopped {
+		return
+	}
+
+	if ingester.recorder != nil {
+		if err := ingester.recorder.Record(topic, raw); err != nil {
+			log.Error().Err(err).Msg("Could not record message")
+		}
+	}
+
+	recordMessage(CurrentConfig().Topics, topic)
+
+	var payload Payload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		log.Error().Err(err).Msg("Payload unmarshalling failed")
+		unmarshal_fail_metric.Inc()
+		return
+	}
+
+	// Keep track of duplicates
+	if ingester.deduplicator.Seen(&payload) {
+		log.Debug().Str("topic", topic).Uint64("sequence", payload.SequenceNumber).Msg("Dropping duplicate")
+		duplicates_metric.Inc()
+		return
+	}
+	payload.Time = uint64(int64(payload.Time) + int64(shift/time.Second))
+	payload.SequenceHex = fmt.Sprintf("%X", payload.SequenceNumber)
+	payload.FormattedTime = time.Unix(int64(payload.Time), 0).UTC().Format(TimeFormat)
+	payload.Mhz = float64(payload.Frequency) / 1000000
+
+	// Calculate distance between stations, best effort
+	locate(&payload)
+
+	// Where the stations are, as far as DXCC goes
+	if dxccTable != nil {
+		payload.SenderDxcc = dxccTable.Resolve(payload.SenderCallsign, payload.SenderCountry)
+		payload.ReceiverDxcc = dxccTable.Resolve(payload.ReceiverCallsign, payload.ReceiverCountry)
+	}
+
+	// Count it before the spotlog gets a chance to hold things up
+	current := CurrentConfig()
+	RecordMetrics(current, topic, &payload)
+
+	// Tell about watched stations and squares turning up
+	if watchList != nil {
+		for _, event := range watchList.Match(current, &payload) {
+			announceWatch(current, event)
+		}
+	}
+
+	if deliver(current.SpotsBackpressure, ingester.spots, &payload) {
+		log.Debug().Str("policy", current.SpotsBackpressure).Msg("Spotlog falling behind, dropped a spot")
+		dropped_metric.WithLabelValues(current.SpotsBackpressure).Inc()
+	}
+}
+
+// Stop waits for messages being ingested, and ignores any after that
+func (ingester *Ingester) Stop() {
+	ingester.lock.Lock()
+	ingester.stopped = true
+	ingester.lock.Unlock()
+
+	if ingester.recorder != nil {
+		if err := ingester.recorder.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close recording")
+		}
+	}
+}
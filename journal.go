@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Compact the journal once this share of its records has fallen out of retention
+const SpotJournalCompactRatio = 0.1
+
+// SpotJournal is an append-only file of newline-delimited JSON spots, replayed
+// into the spotlog on startup so that history survives a restart
+type SpotJournal struct {
+	path  string
+	file  *os.File
+	live  int
+	stale int
+	lock  sync.Mutex
+
+	// Records appended while compacting, to be added to the compacted file
+	compacting bool
+	pending    [][]byte
+	compacted  sync.WaitGroup
+}
+
+func OpenSpotJournal(path string) (*SpotJournal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SpotJournal{
+		path: path,
+		file: file,
+	}, nil
+}
+
+// Replay reads back every spot not older than cutoff, in time order
+func (journal *SpotJournal) Replay(cutoff uint64) ([]*Payload, error) {
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+
+	file, err := os.Open(journal.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	spots := make([]*Payload, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var spot Payload
+		if err := json.Unmarshal(scanner.Bytes(), &spot); err != nil {
+			// Most likely a record cut short by a crash, nothing to do but skip it
+			log.Warn().Err(err).Str("path", journal.path).Msg("Skipping unreadable spot journal record")
+			journal.stale += 1
+			continue
+		}
+		if spot.Time < cutoff {
+			journal.stale += 1
+			continue
+		}
+		// Whether the distance is to be trusted isn't journaled, so work it
+		// out again like on the way in
+		locate(&spot)
+		spots = append(spots, &spot)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(spots, func(i, j int) bool {
+		return spots[i].Time < spots[j].Time
+	})
+	journal.live = len(spots)
+
+	log.Info().Str("path", journal.path).Int("spots", journal.live).Int("stale", journal.stale).Msg("Spot journal replayed")
+
+	return spots, nil
+}
+
+func (journal *SpotJournal) Append(spot *Payload) error {
+	record, err := json.Marshal(spot)
+	if err != nil {
+		return err
+	}
+
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+
+	record = append(record, '\n')
+	if _, err := journal.file.Write(record); err != nil {
+		return err
+	}
+	journal.live += 1
+	if journal.compacting {
+		journal.pending = append(journal.pending, record)
+	}
+
+	return nil
+}
+
+// Compact rewrites the journal to hold only the retained spots, but only after
+// enough of the existing records have gone stale to make it worthwhile, and
+// only then asks for the spots themselves. The rewrite happens in the
+// background, appends going on as usual, and the journal only switches over
+// to the compacted file once it's complete.
+func (journal *SpotJournal) Compact(retained int, retainedSpots func() []*Payload) {
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+
+	journal.stale += journal.live - retained
+	journal.live = retained
+	if journal.compacting || float64(journal.stale) < float64(journal.live)*SpotJournalCompactRatio {
+		return
+	}
+	spots := retainedSpots()
+
+	log.Debug().Str("path", journal.path).Int("spots", journal.live).Int("stale", journal.stale).Msg("Compacting spot journal")
+
+	journal.compacting = true
+	journal.compacted.Add(1)
+	go func() {
+		defer journal.compacted.Done()
+		if err := journal.rewrite(spots); err != nil {
+			log.Error().Err(err).Str("path", journal.path).Msg("Could not compact spot journal")
+		}
+	}()
+}
+
+// Write the spots to a new file, then, holding the journal, the records that
+// were appended in the meantime, and put the new file in place of the old one
+func (journal *SpotJournal) rewrite(spots []*Payload) error {
+	temporary := journal.path + ".tmp"
+	file, err := os.OpenFile(temporary, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0644)
+	if err == nil {
+		err = writeSpots(file, spots)
+	}
+
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+
+	pending := journal.pending
+	journal.compacting = false
+	journal.pending = nil
+	for _, record := range pending {
+		if err != nil {
+			break
+		}
+		_, err = file.Write(record)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(temporary, journal.path)
+	}
+	if err != nil {
+		// The old file is still whole, keep appending to it
+		if file != nil {
+			file.Close()
+			os.Remove(temporary)
+		}
+		return err
+	}
+
+	// The new file has taken the old one's name, keep appending to it
+	previous := journal.file
+	journal.file = file
+	journal.stale = 0
+	previous.Close()
+
+	return nil
+}
+
+func writeSpots(file *os.File, spots []*Payload) error {
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, spot := range spots {
+		if err := encoder.Encode(spot); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func (journal *SpotJournal) Close() error {
+	journal.compacted.Wait()
+
+	journal.lock.Lock()
+	defer journal.lock.Unlock()
+
+	if err := journal.file.Sync(); err != nil {
+		journal.file.Close()
+		return err
+	}
+	return journal.file.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpotJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spots.ndjson")
+
+	journal, err := OpenSpotJournal(path)
+	if err != nil {
+		t.Fatalf("OpenSpotJournal() error = %v", err)
+	}
+	for _, spot := range []*Payload{
+		{SequenceNumber: 3, Time: 300, Band: "2m"},
+		{SequenceNumber: 1, Time: 100, Band: "6m"},
+		{SequenceNumber: 2, Time: 200, Band: "70cm"},
+	} {
+		if err := journal.Append(spot); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	journal.Close()
+
+	journal, err = OpenSpotJournal(path)
+	if err != nil {
+		t.Fatalf("OpenSpotJournal() error = %v", err)
+	}
+	spots, err := journal.Replay(200)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if len(spots) != 2 || spots[0].SequenceNumber != 2 || spots[1].SequenceNumber != 3 {
+		t.Errorf("Replay() = %v, want sequences 2 and 3 in time order", spots)
+	}
+
+	// Appended while compacting, or right after
+	journal.Compact(1, func() []*Payload { return spots[1:] })
+	if err := journal.Append(&Payload{SequenceNumber: 4, Time: 400}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	journal.Close()
+
+	journal, err = OpenSpotJournal(path)
+	if err != nil {
+		t.Fatalf("OpenSpotJournal() error = %v", err)
+	}
+	defer journal.Close()
+	spots, err = journal.Replay(0)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if len(spots) != 2 || spots[0].SequenceNumber != 3 || spots[1].SequenceNumber != 4 {
+		t.Errorf("Replay() after Compact() = %v, want sequences 3 and 4", spots)
+	}
+}
+
+func TestSpotJournalCompactFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spots.ndjson")
+
+	journal, err := OpenSpotJournal(path)
+	if err != nil {
+		t.Fatalf("OpenSpotJournal() error = %v", err)
+	}
+	spots := []*Payload{{SequenceNumber: 1, Time: 100}, {SequenceNumber: 2, Time: 200}}
+	for _, spot := range spots {
+		if err := journal.Append(spot); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	// Nowhere to write the compacted file, so the journal stays as it was
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	journal.Compact(1, func() []*Payload { return spots[1:] })
+	journal.compacted.Wait()
+	if err := journal.Append(&Payload{SequenceNumber: 3, Time: 300}); err != nil {
+		t.Errorf("Append() after failed Compact() error = %v", err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Errorf("Close() after failed Compact() error = %v", err)
+	}
+
+	journal, err = OpenSpotJournal(path)
+	if err != nil {
+		t.Fatalf("OpenSpotJournal() error = %v", err)
+	}
+	defer journal.Close()
+	replayed, err := journal.Replay(0)
+	if err != nil || len(replayed) != 3 {
+		t.Errorf("Replay() = %v, %v, want all 3 spots", replayed, err)
+	}
+}
+
+func TestSpotJournalLocated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spots.ndjson")
+
+	journal, err := OpenSpotJournal(path)
+	if err != nil {
+		t.Fatalf("OpenSpotJournal() error = %v", err)
+	}
+	defer journal.Close()
+	for _, spot := range []*Payload{
+		{SequenceNumber: 1, Time: 100, SenderLocator: "KP20", ReceiverLocator: "kp20"},
+		{SequenceNumber: 2, Time: 200, SenderLocator: "KP20", ReceiverLocator: ""},
+	} {
+		locate(spot)
+		if err := journal.Append(spot); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	// Both ends in the same square are 0 km apart, which is still a distance
+	spots, err := journal.Replay(0)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	filter := Filter{Enabled: true, MaxDistance: pointer(int64(10))}
+	if len(spots) != 2 || !spots[0].located || spots[0].Distance != 0 || !filter.filter(spots[0]) {
+		t.Fatalf("Replay() = %+v, want the first spot located 0 km apart", spots)
+	}
+	if spots[1].located || filter.filter(spots[1]) {
+		t.Errorf("Replay() = %+v, want the second spot without a distance", spots[1])
+	}
+}
@@ -1,20 +1,105 @@
 package main
 
 import (
+	"context"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
+const ShutdownTimeout = time.Second * 10
+
 func main() {
 	zerolog.TimeFieldFormat = time.RFC3339Nano
 
 	var config = NewConfig()
 	log.Debug().Any("config", config).Msg("")
 
-	SetupMetrics()
-	go Metrics(config.MetricsAddrPort)
+	var err error
+	if watchList, err = LoadWatchList(*config); err != nil {
+		log.Fatal().Err(err).Msg("Could not read watch list")
+	}
+
+	// The first SIGINT or SIGTERM shuts down gracefully, a second one doesn't
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan struct{}, 1)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for caught := range sig {
+			if caught != syscall.SIGHUP {
+				log.Info().Any("signal", caught).Msg("Signal caught, shutting down")
+				signal.Reset(os.Interrupt, syscall.SIGTERM)
+				cancel()
+				return
+			}
+
+			config, err := ReloadConfig()
+			if err != nil {
+				log.Error().Err(err).Msg("Could not reload configuration, keeping the previous one")
+				continue
+			}
+			log.Info().Any("config", config).Msg("Configuration reloaded")
+			if err := watchList.Reload(config); err != nil {
+				log.Error().Err(err).Msg("Could not reload watch list, keeping the previous one")
+			}
+			select {
+			case reloads <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	if dxccTable, err = NewDxccTable(config.DxccFile); err != nil {
+		log.Fatal().Err(err).Msg("Could not read DXCC prefix table")
+	}
+
+	SetupMetrics(*config)
+	metricsServer := Metrics(config.MetricsAddrPort)
+
 	spots := make(chan *Payload, 1000)
-	go Spotlog(*config, spots)
-	Subscribe(*config, spots)
+	MonitorSpots(spots)
+	spotlogDone := make(chan struct{})
+	go func() {
+		defer close(spotlogDone)
+		Spotlog(*config, spots)
+	}()
+
+	var recorder *Recorder
+	if config.RecordFile != "" {
+		if recorder, err = OpenRecorder(config.RecordFile); err != nil {
+			log.Fatal().Err(err).Str("path", config.RecordFile).Msg("Could not open recording")
+		}
+	}
+	ingester := NewIngester(ctx, spots, recorder)
+
+	if config.ReplayFile != "" {
+		ReplayFile(ctx, config.ReplayFile, config.ReplaySpeed, ingester)
+	} else {
+		Subscribe(ctx, *config, ingester, reloads)
+	}
+
+	// Nothing more is coming in, so let the spotlog catch up and wind down
+	ingester.Stop()
+	close(spots)
+	<-spotlogDone
+	waitWebhooks()
+
+	shutdownServer(metricsServer)
+	log.Info().Msg("Shut down")
+}
+
+// Stop accepting connections and wait for the ongoing requests, for a while
+func shutdownServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Str("addrport", server.Addr).Msg("Could not shut down server cleanly")
+	}
 }
@@ -0,0 +1,271 @@
+package main
+
+import (
+	_ "embed"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"text/template"
+)
+
+// Country outlines from Natural Earth's public domain 1:110m data, as rings of
+// flattened longitude, latitude pairs, so that the map needs no tile server
+//
+//go:embed world.json
+var worldJson []byte
+
+var mapTemplate *template.Template
+
+func mapHandler(getConfig func() Config) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		config := getConfig()
+		log.Debug().Msg("Serving a map")
+
+		filter := NewFilter(config, request)
+		if err := filter.Err(); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		if err := mapTemplate.Execute(writer, struct {
+			Config Config
+			Filter Filter
+		}{
+			Config: config,
+			Filter: filter,
+		}); err != nil {
+			log.Error().Err(err).Msg("Failed to render map template")
+		}
+	}
+}
+
+func worldHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "public, max-age=86400")
+	writer.Write(worldJson)
+}
+
+const mapHtml = `<!DOCTYPE html>
+<html lang="en">
+	<head>
+		<meta charset="utf-8">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<meta name="author" content="Joni OH2EWL">
+		<meta name="description" content="Live map of PSK Reporter's spots from and to {{range $i, $country := .Config.Countries}}{{if $i}}, {{end}}{{$country}}{{end}}">
+		<title>
+		Spotlog map
+		{{range .Filter.Terms}}
+		{{html .}}
+		{{end}}
+		</title>
+		<style>
+		body {
+			font-family: monospace;
+			margin: 0;
+			background-color: #ffffff;
+		}
+		header {
+			padding: 0.5em;
+		}
+		canvas {
+			display: block;
+			width: 100%;
+		}
+		.band {
+			display: inline-block;
+			margin-right: 1em;
+		}
+		.swatch {
+			display: inline-block;
+			width: 1em;
+			height: 0.5em;
+			margin-right: 0.3em;
+		}
+		</style>
+	</head>
+	<body>
+		<header>
+			<a id="table" href="/">Spotlog</a>
+			map of the last <strong id="age"></strong> minutes,
+			{{if .Filter.Enabled}}
+			filter
+			<strong>
+			{{range .Filter.Terms}}
+			{{html .}}
+			{{end}}
+			</strong>
+			{{else}}
+			unfiltered
+			{{end}}
+			<div id="legend"></div>
+		</header>
+
+		<canvas id="map"></canvas>
+
+		<script>
+		const search = new URLSearchParams(window.location.search);
+		const maxAge = (parseInt(search.get('age')) || 60) * 60;
+		const colors = {
+			'6m': '#e6194b', '4m': '#f58231', '2m': '#3cb44b', '70cm': '#4363d8',
+			'23cm': '#911eb4', '13cm': '#f032e6', '10m': '#9a6324', '20m': '#808000',
+		};
+		const fallback = '#000000';
+		const canvas = document.getElementById('map');
+		const context = canvas.getContext('2d');
+		let world = [];
+		let paths = [];
+
+		document.getElementById('table').href = '/' + window.location.search;
+		document.getElementById('age').textContent = maxAge / 60;
+
+		// Center of a Maidenhead grid square, as [longitude, latitude]
+		function locatorPoint(locator) {
+			locator = locator.toUpperCase();
+			if (locator.length < 2 || locator.length % 2 != 0) {
+				return null;
+			}
+			let longitude = -180, latitude = -90, width = 20, height = 10;
+			for (let i = 0; i < Math.min(locator.length, 6); i += 2) {
+				const base = i == 2 ? 48 : 65;
+				const x = locator.charCodeAt(i) - base, y = locator.charCodeAt(i + 1) - base;
+				const steps = i == 0 ? 18 : i == 2 ? 10 : 24;
+				if (!(x >= 0 && x < steps && y >= 0 && y < steps)) {
+					return null;
+				}
+				longitude += x * width;
+				latitude += y * height;
+				if (i + 2 < Math.min(locator.length, 6)) {
+					width /= i == 0 ? 10 : 24;
+					height /= i == 0 ? 10 : 24;
+				}
+			}
+			return [longitude + width / 2, latitude + height / 2];
+		}
+
+		// Points along the great circle between two points
+		function greatCircle(from, to) {
+			const radians = Math.PI / 180;
+			const vector = ([longitude, latitude]) => [
+				Math.cos(latitude * radians) * Math.cos(longitude * radians),
+				Math.cos(latitude * radians) * Math.sin(longitude * radians),
+				Math.sin(latitude * radians),
+			];
+			const a = vector(from), b = vector(to);
+			const angle = Math.acos(Math.min(1, Math.max(-1, a[0] * b[0] + a[1] * b[1] + a[2] * b[2])));
+			const segments = Math.max(1, Math.min(64, Math.round(angle / radians)));
+			const points = [];
+			for (let i = 0; i <= segments; i++) {
+				const t = i / segments;
+				const s = Math.sin(angle);
+				const wa = s < 1e-9 ? 1 - t : Math.sin((1 - t) * angle) / s;
+				const wb = s < 1e-9 ? t : Math.sin(t * angle) / s;
+				const x = wa * a[0] + wb * b[0], y = wa * a[1] + wb * b[1], z = wa * a[2] + wb * b[2];
+				points.push([Math.atan2(y, x) / radians, Math.atan2(z, Math.sqrt(x * x + y * y)) / radians]);
+			}
+			return points;
+		}
+
+		function add(spot) {
+			const sender = locatorPoint(spot.sl), receiver = locatorPoint(spot.rl);
+			if (sender == null || receiver == null) {
+				return;
+			}
+			paths.push({time: spot.t, band: spot.b, points: greatCircle(sender, receiver)});
+		}
+
+		function resize() {
+			canvas.width = canvas.clientWidth * window.devicePixelRatio;
+			canvas.height = canvas.width / 2;
+		}
+
+		function project([longitude, latitude]) {
+			return [(longitude + 180) / 360 * canvas.width, (90 - latitude) / 180 * canvas.height];
+		}
+
+		function line(points) {
+			context.beginPath();
+			let previous = null;
+			for (const point of points) {
+				const [x, y] = project(point);
+				if (previous == null || Math.abs(point[0] - previous[0]) > 180) {
+					context.moveTo(x, y);
+				} else {
+					context.lineTo(x, y);
+				}
+				previous = point;
+			}
+		}
+
+		function draw() {
+			const now = Date.now() / 1000;
+			paths = paths.filter(path => now - path.time < maxAge);
+
+			context.fillStyle = '#f4f8fb';
+			context.fillRect(0, 0, canvas.width, canvas.height);
+			context.fillStyle = '#dddddd';
+			context.strokeStyle = '#999999';
+			context.lineWidth = window.devicePixelRatio * 0.5;
+			for (const ring of world) {
+				const points = [];
+				for (let i = 0; i < ring.length; i += 2) {
+					points.push([ring[i], ring[i + 1]]);
+				}
+				line(points);
+				context.closePath();
+				context.fill();
+				context.stroke();
+			}
+
+			context.lineWidth = window.devicePixelRatio * 1.5;
+			for (const path of paths) {
+				context.globalAlpha = Math.max(0.05, 1 - (now - path.time) / maxAge);
+				context.strokeStyle = colors[path.band] || fallback;
+				line(path.points);
+				context.stroke();
+			}
+			context.globalAlpha = 1;
+
+			const legend = document.getElementById('legend');
+			legend.replaceChildren();
+			for (const band of [...new Set(paths.map(path => path.band))].sort()) {
+				const item = document.createElement('span');
+				item.className = 'band';
+				const swatch = document.createElement('span');
+				swatch.className = 'swatch';
+				swatch.style.backgroundColor = colors[band] || fallback;
+				item.append(swatch, band);
+				legend.append(item);
+			}
+		}
+
+		window.addEventListener('resize', () => { resize(); draw(); });
+		resize();
+
+		fetch('/map/world.json').then(response => response.json()).then(rings => {
+			world = rings;
+			draw();
+		});
+
+		// The newest spots, should there be more than fit on a page, drawn
+		// oldest first like the ones streaming in
+		const initial = new URLSearchParams(search);
+		initial.set('since', Math.floor(Date.now() / 1000 - maxAge));
+		initial.set('limit', 10000);
+		initial.set('order', 'newest');
+		fetch('/api/spots?' + initial).then(response => response.json()).then(response => {
+			response.spots.reverse().forEach(add);
+			draw();
+		});
+
+		const stream = new URLSearchParams(search);
+		stream.set('format', 'json');
+		const spots = new EventSource('/stream/?' + stream);
+		spots.onmessage = function(event) {
+			add(JSON.parse(event.data));
+		};
+
+		setInterval(draw, 1000);
+		</script>
+	</body>
+</html>
+`
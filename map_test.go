@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestMapHandler(t *testing.T) {
+	mapTemplate = template.Must(template.New("map").Parse(mapHtml))
+	config := Config{Bands: []string{"2m", "70cm"}, Countries: []int{224, 284}}
+	handler := mapHandler(func() Config { return config })
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/map?bands=70cm", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("Content-Type = %q, want HTML", got)
+	}
+	page := recorder.Body.String()
+	for _, want := range []string{"224, 284", "70cm", "initial.set('order', 'newest')", "/map/world.json"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("map page doesn't have %q", want)
+		}
+	}
+
+	// A query that doesn't parse is refused, like everywhere else
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/map?q=band+%3D", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestWorldHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	worldHandler(recorder, httptest.NewRequest("GET", "/map/world.json", nil))
+	if got := recorder.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := recorder.Header().Get("Cache-Control"); !strings.Contains(got, "max-age") {
+		t.Errorf("Cache-Control = %q, want it cached", got)
+	}
+
+	// Rings of longitude, latitude pairs, all on the globe
+	var rings [][]float64
+	if err := json.NewDecoder(recorder.Body).Decode(&rings); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if len(rings) < 100 {
+		t.Errorf("world.json has %d rings, want the countries of the world", len(rings))
+	}
+	for i, ring := range rings {
+		if len(ring) < 6 || len(ring)%2 != 0 {
+			t.Errorf("ring %d has %d numbers, want longitude, latitude pairs of at least a triangle", i, len(ring))
+			continue
+		}
+		for j := 0; j < len(ring); j += 2 {
+			if ring[j] < -180 || ring[j] > 180 || ring[j+1] < -90 || ring[j+1] > 90 {
+				t.Errorf("ring %d has %v, %v, off the globe", i, ring[j], ring[j+1])
+				break
+			}
+		}
+	}
+}
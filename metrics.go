@@ -1,11 +1,15 @@
 package main
 
 import (
+	"errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -13,35 +17,214 @@ const (
 	Subsystem = "spots"
 )
 
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+	DirectionLocal    = "local"
+)
+
 var (
-	sent_metric     *prometheus.CounterVec
-	received_metric *prometheus.CounterVec
-	local_metric    *prometheus.CounterVec
+	sent_metric         *prometheus.CounterVec
+	received_metric     *prometheus.CounterVec
+	local_metric        *prometheus.CounterVec
+	cross_border_metric *prometheus.CounterVec
+	distance_metric     *prometheus.HistogramVec
+	report_metric       *prometheus.HistogramVec
+	watched_metric      *prometheus.CounterVec
+	duplicates_metric   prometheus.Counter
+	active_stations     *ActiveStations
+	opening_detector    *OpeningDetector
+	spot_windows        *SpotWindows
 )
 
-func SetupMetrics() {
+// Classification places a spot relative to one of the monitored countries
+type Classification struct {
+	Country   int
+	Direction string
+}
+
+func SetupMetrics(config Config) {
+	// The far end's DXCC details, if asked for
+	remoteLabels := []string{"country", "band", "mode"}
+	for _, label := range config.MetricsDxccLabels {
+		remoteLabels = append(remoteLabels, "remote_"+label)
+	}
+
 	sent_metric = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: Namespace,
 		Subsystem: Subsystem,
 		Name:      "sent_total",
-	}, []string{"country", "band", "mode"})
+	}, remoteLabels)
 
 	received_metric = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: Namespace,
 		Subsystem: Subsystem,
 		Name:      "received_total",
-	}, []string{"country", "band", "mode"})
+	}, remoteLabels)
 
 	local_metric = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: Namespace,
 		Subsystem: Subsystem,
 		Name:      "local_total",
 	}, []string{"country", "band", "mode"})
+
+	cross_border_metric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "cross_border_total",
+	}, []string{"sender_country", "receiver_country", "band", "mode"})
+
+	distance_metric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "distance_km",
+		Help:      "Distance between the sender's and receiver's grid square centers",
+		Buckets:   []float64{50, 100, 200, 300, 500, 750, 1000, 1500, 2000, 2500, 3000, 5000, 10000},
+	}, []string{"country", "band", "mode", "direction"})
+
+	report_metric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: Subsystem,
+		Name:      "report_db",
+		Help:      "Signal report, i.e. SNR, given by the receiver",
+		Buckets:   prometheus.LinearBuckets(-30, 3, 21),
+	}, []string{"country", "band", "mode", "direction"})
+
+	duplicates_metric = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "duplicates_total",
+		Help:      "Spots dropped for having been delivered already, e.g. over another topic",
+	})
+
+	SetupHealthMetrics()
+
+	// Opt-in, as these scale with the number of stations
+	if len(config.ActiveStationsWindows) > 0 {
+		active_stations = NewActiveStations(config.ActiveStationsWindows)
+		prometheus.MustRegister(active_stations)
+	}
+
+	opening_detector = NewOpeningDetector()
+	prometheus.MustRegister(opening_detector)
+
+	spot_windows = NewSpotWindows()
+	prometheus.MustRegister(spot_windows)
+
+	if len(config.WatchCallsigns) > 0 {
+		watched_metric = promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "watched_total",
+			Help:      "Spots sent or received by the callsigns in WATCH_CALLSIGNS",
+		}, []string{"callsign", "band", "mode", "direction"})
+	}
 }
 
-func Metrics(addrPort string) {
-	http.Handle("/metrics", promhttp.Handler())
-	if err := http.ListenAndServe(addrPort, nil); err != nil {
-		log.Fatal().Err(err).Str("addrport", addrPort).Msg("Could not expose Prometheus metrics")
+// Classify a spot as sent, received, or local, once for every monitored
+// country it involves
+func Classify(config Config, spot *Payload) []Classification {
+	var classifications []Classification
+
+	for _, country := range config.Countries {
+		if spot.SenderCountry == country && spot.ReceiverCountry == country {
+			classifications = append(classifications, Classification{Country: country, Direction: DirectionLocal})
+		} else if spot.SenderCountry == country {
+			classifications = append(classifications, Classification{Country: country, Direction: DirectionSent})
+		} else if spot.ReceiverCountry == country {
+			classifications = append(classifications, Classification{Country: country, Direction: DirectionReceived})
+		}
+	}
+
+	return classifications
+}
+
+func RecordMetrics(config Config, topic string, spot *Payload) {
+	classifications := Classify(config, spot)
+	if classifications == nil {
+		// Not sure how we got here
+		log.Debug().Str("topic", topic).Any("payload", spot).Msg("No country matches, skipping")
+		return
+	}
+
+	for _, classification := range classifications {
+		country := strconv.Itoa(classification.Country)
+		switch classification.Direction {
+		case DirectionLocal:
+			log.Debug().Str("topic", topic).Any("payload", spot).Msg("Recording message within same country")
+			local_metric.WithLabelValues(country, spot.Band, spot.Mode).Inc()
+		case DirectionSent:
+			log.Debug().Str("topic", topic).Any("payload", spot).Msg("Recording message sent from target country")
+			sent_metric.WithLabelValues(append([]string{country, spot.Band, spot.Mode}, dxccLabelValues(config.MetricsDxccLabels, spot.ReceiverDxcc)...)...).Inc()
+		case DirectionReceived:
+			log.Debug().Str("topic", topic).Any("payload", spot).Msg("Recording message received in target country")
+			received_metric.WithLabelValues(append([]string{country, spot.Band, spot.Mode}, dxccLabelValues(config.MetricsDxccLabels, spot.SenderDxcc)...)...).Inc()
+		}
+
+		if spot.located {
+			distance_metric.WithLabelValues(country, spot.Band, spot.Mode, classification.Direction).Observe(float64(spot.Distance))
+		}
+		report_metric.WithLabelValues(country, spot.Band, spot.Mode, classification.Direction).Observe(float64(spot.Report))
+	}
+
+	if active_stations != nil {
+		active_stations.Observe(spot, classifications)
+	}
+
+	if opening_detector != nil {
+		for _, event := range opening_detector.Observe(config, spot, classifications) {
+			announceOpening(config, event)
+		}
 	}
+
+	if watched_metric != nil {
+		if callsign := strings.ToUpper(spot.SenderCallsign); slices.Contains(config.WatchCallsigns, callsign) {
+			watched_metric.WithLabelValues(callsign, spot.Band, spot.Mode, DirectionSent).Inc()
+		}
+		if callsign := strings.ToUpper(spot.ReceiverCallsign); slices.Contains(config.WatchCallsigns, callsign) {
+			watched_metric.WithLabelValues(callsign, spot.Band, spot.Mode, DirectionReceived).Inc()
+		}
+	}
+
+	// Both ends are monitored, but in different countries
+	if len(classifications) > 1 {
+		log.Debug().Str("topic", topic).Any("payload", spot).Msg("Recording message across monitored countries")
+		cross_border_metric.WithLabelValues(strconv.Itoa(spot.SenderCountry), strconv.Itoa(spot.ReceiverCountry), spot.Band, spot.Mode).Inc()
+	}
+}
+
+// Values for the DXCC labels, left empty for stations that couldn't be placed
+func dxccLabelValues(labels []string, entity *DxccEntity) []string {
+	values := make([]string, len(labels))
+	if entity == nil {
+		return values
+	}
+	for i, label := range labels {
+		switch label {
+		case "entity":
+			values[i] = entity.Name
+		case "continent":
+			values[i] = entity.Continent
+		case "cq_zone":
+			values[i] = strconv.Itoa(entity.CqZone)
+		case "itu_zone":
+			values[i] = strconv.Itoa(entity.ItuZone)
+		}
+	}
+	return values
+}
+
+func Metrics(addrPort string) *http.Server {
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsMux.HandleFunc("/healthz", healthzHandler)
+	metricsMux.HandleFunc("/readyz", readyzHandler)
+
+	server := &http.Server{Addr: addrPort, Handler: metricsMux}
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal().Err(err).Str("addrport", addrPort).Msg("Could not expose Prometheus metrics")
+		}
+	}()
+
+	return server
 }
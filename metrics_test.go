@@ -0,0 +1,118 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	one := Config{Countries: []int{224}}
+	several := Config{Countries: []int{224, 284, 266}}
+	tests := []struct {
+		name   string
+		config Config
+		spot   Payload
+		want   []Classification
+	}{
+		{"sent", one, Payload{SenderCountry: 224, ReceiverCountry: 284}, []Classification{{224, DirectionSent}}},
+		{"received", one, Payload{SenderCountry: 284, ReceiverCountry: 224}, []Classification{{224, DirectionReceived}}},
+		{"local", one, Payload{SenderCountry: 224, ReceiverCountry: 224}, []Classification{{224, DirectionLocal}}},
+		{"elsewhere", one, Payload{SenderCountry: 284, ReceiverCountry: 266}, nil},
+		{"sent of several", several, Payload{SenderCountry: 284, ReceiverCountry: 1}, []Classification{{284, DirectionSent}}},
+		{"received of several", several, Payload{SenderCountry: 1, ReceiverCountry: 266}, []Classification{{266, DirectionReceived}}},
+		{"local of several", several, Payload{SenderCountry: 284, ReceiverCountry: 284}, []Classification{{284, DirectionLocal}}},
+		{"cross-border", several, Payload{SenderCountry: 266, ReceiverCountry: 224}, []Classification{{224, DirectionReceived}, {266, DirectionSent}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.config, &tt.spot); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Classify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordMetricsCountries(t *testing.T) {
+	setupMetricsOnce.Do(func() {
+		SetupMetrics(Config{})
+	})
+	config := Config{Countries: []int{224, 284}}
+
+	// Counted once for each monitored country, in its own direction, and once
+	// across the border; a local spot doesn't cross any
+	counters := []struct {
+		name    string
+		counter prometheus.Counter
+		change  float64
+	}{
+		{"sent from 224", sent_metric.WithLabelValues("224", "2m", "FT8"), 1},
+		{"received in 284", received_metric.WithLabelValues("284", "2m", "FT8"), 1},
+		{"received in 224", received_metric.WithLabelValues("224", "2m", "FT8"), 0},
+		{"local to 284", local_metric.WithLabelValues("284", "2m", "FT8"), 1},
+		{"local to 224", local_metric.WithLabelValues("224", "2m", "FT8"), 0},
+		{"224 to 284", cross_border_metric.WithLabelValues("224", "284", "2m", "FT8"), 1},
+		{"284 to 284", cross_border_metric.WithLabelValues("284", "284", "2m", "FT8"), 0},
+	}
+	before := make([]float64, len(counters))
+	for i, counter := range counters {
+		before[i] = testutil.ToFloat64(counter.counter)
+	}
+
+	RecordMetrics(config, "test", &Payload{Band: "2m", Mode: "FT8", SenderCountry: 224, ReceiverCountry: 284})
+	RecordMetrics(config, "test", &Payload{Band: "2m", Mode: "FT8", SenderCountry: 284, ReceiverCountry: 284})
+
+	for i, counter := range counters {
+		if got := testutil.ToFloat64(counter.counter) - before[i]; got != counter.change {
+			t.Errorf("%s went up by %v, want %v", counter.name, got, counter.change)
+		}
+	}
+}
+
+func TestRecordMetricsHistograms(t *testing.T) {
+	setupMetricsOnce.Do(func() {
+		SetupMetrics(Config{})
+	})
+	distance_metric.Reset()
+	report_metric.Reset()
+	config := Config{Countries: []int{224}}
+
+	located := &Payload{Band: "2m", Mode: "FT8", Report: -7, SenderCountry: 224, ReceiverCountry: 284, SenderLocator: "KP20", ReceiverLocator: "JO89"}
+	locate(located)
+	RecordMetrics(config, "test", located)
+	// Nowhere to measure from, so there's a report but no distance
+	RecordMetrics(config, "test", &Payload{Band: "2m", Mode: "FT4", Report: 3, SenderCountry: 224, ReceiverCountry: 284, SenderLocator: "KP20"})
+
+	if got := testutil.CollectAndCount(distance_metric); got != 1 {
+		t.Errorf("distance series = %d, want only the located spot's", got)
+	}
+	if got := testutil.CollectAndCount(report_metric); got != 2 {
+		t.Errorf("report series = %d, want both spots'", got)
+	}
+
+	want := `
+# HELP pskreporter_spots_distance_km Distance between the sender's and receiver's grid square centers
+# TYPE pskreporter_spots_distance_km histogram
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="50"} 0
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="100"} 0
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="200"} 0
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="300"} 0
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="500"} 1
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="750"} 1
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="1000"} 1
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="1500"} 1
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="2000"} 1
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="2500"} 1
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="3000"} 1
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="5000"} 1
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="10000"} 1
+pskreporter_spots_distance_km_bucket{band="2m",country="224",direction="sent",mode="FT8",le="+Inf"} 1
+pskreporter_spots_distance_km_sum{band="2m",country="224",direction="sent",mode="FT8"} 458
+pskreporter_spots_distance_km_count{band="2m",country="224",direction="sent",mode="FT8"} 1
+`
+	if err := testutil.CollectAndCompare(distance_metric, strings.NewReader(want)); err != nil {
+		t.Errorf("distance histogram: %v", err)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Spots are counted by the minute, and compared over a quarter of an hour
+	OpeningBucket = time.Minute
+	OpeningWindow = 15
+
+	OpeningSamplePaths = 5
+	OpeningStarted     = "opening_started"
+	OpeningEnded       = "opening_ended"
+
+	// Receivers' clocks are off by this much at most, as far as keeping time
+	// by live spots goes
+	MaxSpotAhead = 2 * time.Minute
+)
+
+// The clock live spots are held to, for tests to set
+var spotClock = time.Now
+
+// OpeningPath is one of the longest paths heard during an opening
+type OpeningPath struct {
+	SenderCallsign   string `json:"sc"`
+	SenderLocator    string `json:"sl"`
+	ReceiverCallsign string `json:"rc"`
+	ReceiverLocator  string `json:"rl"`
+	Distance         int64  `json:"distance"`
+	Time             uint64 `json:"t"`
+}
+
+// OpeningEvent tells that a band opened, or closed again, in one direction
+// from or to a monitored country. Rates are spots a minute, and distances the
+// 90th percentile, both over the window and at their highest so far, and for
+// the baseline the same smoothed over OPENING_BASELINE.
+type OpeningEvent struct {
+	Type             string        `json:"type"`
+	Country          int           `json:"country"`
+	Band             string        `json:"band"`
+	Direction        string        `json:"direction"`
+	Started          uint64        `json:"started"`
+	Ended            uint64        `json:"ended,omitempty"`
+	Rate             float64       `json:"rate"`
+	BaselineRate     float64       `json:"baseline_rate"`
+	Distance         int64         `json:"distance"`
+	BaselineDistance float64       `json:"baseline_distance"`
+	PeakDistance     int64         `json:"peak_distance"`
+	Paths            []OpeningPath `json:"paths"`
+}
+
+type openingKey struct {
+	Country   int
+	Band      string
+	Direction string
+}
+
+type openingBucket struct {
+	spots     int
+	distances []int64
+	paths     []OpeningPath
+}
+
+type openingState struct {
+	buckets          [OpeningWindow]openingBucket
+	rolled           int
+	located          int
+	baselineRate     float64
+	baselineDistance float64
+	opening          *OpeningEvent
+}
+
+// OpeningDetector compares every band's spot rate and distances, in every
+// direction, against a rolling baseline, telling when they go well above it
+// and when they come back down. Time goes by the spots, so that replays work
+// the same as the live feed, though live spots can't take it ahead of the
+// clock.
+type OpeningDetector struct {
+	bucket int64
+	states map[openingKey]*openingState
+	lock   sync.Mutex
+	desc   *prometheus.Desc
+}
+
+func NewOpeningDetector() *OpeningDetector {
+	return &OpeningDetector{
+		states: make(map[openingKey]*openingState),
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "", "band_opening"),
+			"Whether the band is open in the direction, going by the spot rate and distances against their baseline",
+			[]string{"country", "band", "direction"}, nil,
+		),
+	}
+}
+
+// Observe the spot in all of its classifications, returning the openings
+// that started or ended with the minutes it closed
+func (detector *OpeningDetector) Observe(config Config, spot *Payload, classifications []Classification) []OpeningEvent {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	bucket := int64(spotTime(config, spot)) / int64(OpeningBucket.Seconds())
+	if detector.bucket == 0 {
+		detector.bucket = bucket
+	}
+	events := detector.rollTo(config, bucket)
+
+	for _, classification := range classifications {
+		key := openingKey{Country: classification.Country, Band: spot.Band, Direction: classification.Direction}
+		state, ok := detector.states[key]
+		if !ok {
+			state = &openingState{}
+			detector.states[key] = state
+		}
+
+		current := &state.buckets[detector.bucket%OpeningWindow]
+		current.spots += 1
+		if !spot.located {
+			continue
+		}
+		current.distances = append(current.distances, spot.Distance)
+		path := OpeningPath{
+			SenderCallsign:   spot.SenderCallsign,
+			SenderLocator:    spot.SenderLocator,
+			ReceiverCallsign: spot.ReceiverCallsign,
+			ReceiverLocator:  spot.ReceiverLocator,
+			Distance:         spot.Distance,
+			Time:             spot.Time,
+		}
+		current.paths = addOpeningPath(current.paths, path)
+		if state.opening != nil {
+			state.opening.PeakDistance = max(state.opening.PeakDistance, spot.Distance)
+			state.opening.Paths = addOpeningPath(state.opening.Paths, path)
+		}
+	}
+
+	return events
+}
+
+// Advance closes the minutes the clock has gone past, less MaxSpotAhead, even
+// when no spots come along to do it, so that openings end on a band gone quiet.
+// Replays keep time by their spots alone.
+func (detector *OpeningDetector) Advance(config Config) []OpeningEvent {
+	if config.ReplayFile != "" {
+		return nil
+	}
+
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	// Nothing to close before the first spot
+	if detector.bucket == 0 {
+		return nil
+	}
+	return detector.rollTo(config, spotClock().Add(-MaxSpotAhead).Unix()/int64(OpeningBucket.Seconds()))
+}
+
+// Roll the minutes up to the bucket, if it's ahead
+func (detector *OpeningDetector) rollTo(config Config, bucket int64) []OpeningEvent {
+	var events []OpeningEvent
+	// Over long gaps, the baseline has long since forgotten anyway
+	if steps := bucket - detector.bucket; steps > 0 {
+		for range min(steps, int64(config.OpeningBaseline/OpeningBucket)+OpeningWindow) {
+			events = append(events, detector.roll(config)...)
+		}
+		detector.bucket = bucket
+	}
+	return events
+}
+
+// The spot's time to keep time by. A live spot from a receiver whose clock is
+// well ahead would otherwise take the time with it, and leave everything else
+// in the past until the clock catches up; replays go by their spots alone.
+func spotTime(config Config, spot *Payload) uint64 {
+	if config.ReplayFile != "" {
+		return spot.Time
+	}
+	return min(spot.Time, uint64(spotClock().Add(MaxSpotAhead).Unix()))
+}
+
+// Close the current minute for every band and direction, and start a new one
+func (detector *OpeningDetector) roll(config Config) []OpeningEvent {
+	var events []OpeningEvent
+	closed := uint64((detector.bucket + 1) * int64(OpeningBucket.Seconds()))
+
+	for key, state := range detector.states {
+		state.rolled += 1
+
+		// Quarter of an hour so far
+		var spots int
+		var distances []int64
+		var paths []OpeningPath
+		for _, bucket := range state.buckets {
+			spots += bucket.spots
+			distances = append(distances, bucket.distances...)
+			for _, path := range bucket.paths {
+				paths = addOpeningPath(paths, path)
+			}
+		}
+		rate := float64(spots) / float64(min(state.rolled, OpeningWindow))
+		distance := percentile(distances, 0.9)
+
+		if state.opening == nil {
+			if state.rolled > OpeningWindow && spots >= config.OpeningMinSpots &&
+				(rate >= config.OpeningFactor*state.baselineRate ||
+					state.baselineDistance > 0 && float64(distance) >= config.OpeningFactor*state.baselineDistance) {
+				state.opening = &OpeningEvent{
+					Type:             OpeningStarted,
+					Country:          key.Country,
+					Band:             key.Band,
+					Direction:        key.Direction,
+					Started:          closed,
+					Rate:             rate,
+					BaselineRate:     state.baselineRate,
+					Distance:         distance,
+					BaselineDistance: state.baselineDistance,
+					PeakDistance:     slices.Max(append(distances, 0)),
+					Paths:            paths,
+				}
+				events = append(events, state.opening.snapshot())
+			} else {
+				// Openings would only raise the bar for the next one, so
+				// the baseline learns from the quieter times alone, and
+				// starts out as a plain average
+				weight := max(1/float64(state.rolled), float64(OpeningBucket)/float64(config.OpeningBaseline))
+				state.baselineRate += weight * (rate - state.baselineRate)
+				if len(distances) > 0 {
+					state.located += 1
+					weight := max(1/float64(state.located), float64(OpeningBucket)/float64(config.OpeningBaseline))
+					state.baselineDistance += weight * (float64(distance) - state.baselineDistance)
+				}
+			}
+		} else {
+			// Halfway back down to the baseline, to not flap on the edge
+			threshold := (1 + config.OpeningFactor) / 2
+			state.opening.Rate = max(state.opening.Rate, rate)
+			state.opening.Distance = max(state.opening.Distance, distance)
+			if spots < config.OpeningMinSpots ||
+				rate < threshold*state.baselineRate &&
+					!(state.baselineDistance > 0 && float64(distance) >= threshold*state.baselineDistance) {
+				ended := state.opening.snapshot()
+				ended.Type = OpeningEnded
+				ended.Ended = closed
+				events = append(events, ended)
+				state.opening = nil
+			}
+		}
+
+		state.buckets[(detector.bucket+1)%OpeningWindow] = openingBucket{}
+	}
+
+	detector.bucket += 1
+	return events
+}
+
+// A copy to hand out, while the paths keep changing underneath
+func (event *OpeningEvent) snapshot() OpeningEvent {
+	snapshot := *event
+	snapshot.Paths = slices.Clone(event.Paths)
+	return snapshot
+}
+
+func (detector *OpeningDetector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- detector.desc
+}
+
+func (detector *OpeningDetector) Collect(metrics chan<- prometheus.Metric) {
+	detector.lock.Lock()
+	defer detector.lock.Unlock()
+
+	for key, state := range detector.states {
+		var open float64
+		if state.opening != nil {
+			open = 1
+		}
+		metrics <- prometheus.MustNewConstMetric(detector.desc, prometheus.GaugeValue, open,
+			strconv.Itoa(key.Country), key.Band, key.Direction)
+	}
+}
+
+// Keep the longest paths, each pair of stations once
+func addOpeningPath(paths []OpeningPath, path OpeningPath) []OpeningPath {
+	if i := slices.IndexFunc(paths, func(known OpeningPath) bool {
+		return known.SenderCallsign == path.SenderCallsign && known.ReceiverCallsign == path.ReceiverCallsign
+	}); i >= 0 {
+		if paths[i].Distance >= path.Distance {
+			return paths
+		}
+		paths = slices.Delete(paths, i, i+1)
+	}
+
+	i, _ := slices.BinarySearchFunc(paths, path, func(known OpeningPath, path OpeningPath) int {
+		return int(path.Distance - known.Distance)
+	})
+	if i >= OpeningSamplePaths {
+		return paths
+	}
+	paths = slices.Insert(paths, i, path)
+	return paths[:min(len(paths), OpeningSamplePaths)]
+}
+
+// Nearest-rank percentile, zero when there's nothing to go by
+func percentile(values []int64, fraction float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return sorted[min(len(sorted)-1, int(fraction*float64(len(sorted))))]
+}
+
+// Tell everyone who wants to know about the opening
+func announceOpening(config Config, event OpeningEvent) {
+	log.Info().Str("type", event.Type).Int("country", event.Country).Str("band", event.Band).Str("direction", event.Direction).
+		Float64("rate", event.Rate).Float64("baseline_rate", event.BaselineRate).Int64("peak_distance", event.PeakDistance).
+		Msg("Band opening")
+
+	StreamLock.Lock()
+	for _, streamer := range Streamers {
+		select {
+		case streamer.Openings <- event:
+		default:
+		}
+	}
+	StreamLock.Unlock()
+
+	postWebhooks(config.OpeningWebhooks, "opening", event)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOpeningDetector(t *testing.T) {
+	config := Config{Countries: []int{224}, OpeningFactor: 3, OpeningMinSpots: 10, OpeningBaseline: time.Hour}
+	detector := NewOpeningDetector()
+	start := uint64(1700000000)
+
+	var events []OpeningEvent
+	minutes := func(from int, to int, spotsPerMinute int, distance int64) {
+		for minute := from; minute < to; minute++ {
+			for i := range spotsPerMinute {
+				spot := &Payload{
+					Time:             start + uint64(minute*60+i),
+					Band:             "2m",
+					SenderCountry:    224,
+					ReceiverCountry:  284,
+					SenderCallsign:   "OH2XYZ",
+					ReceiverCallsign: "SM" + string(rune('A'+i)),
+					Distance:         distance + int64(i),
+					located:          true,
+				}
+				events = append(events, detector.Observe(config, spot, Classify(config, spot))...)
+			}
+		}
+	}
+
+	// An hour of the usual, then a sudden flurry of long paths, and back
+	minutes(0, 60, 2, 300)
+	if len(events) != 0 {
+		t.Fatalf("Observe() = %v, want nothing during the usual", events)
+	}
+	minutes(60, 75, 10, 1500)
+	if len(events) != 1 || events[0].Type != OpeningStarted {
+		t.Fatalf("Observe() = %v, want an opening started", events)
+	}
+	started := events[0]
+	if started.Band != "2m" || started.Direction != DirectionSent || started.Country != 224 {
+		t.Errorf("Observe() = %+v, want 2m sent from 224", started)
+	}
+	if started.BaselineRate < 1.5 || started.BaselineRate > 2.5 || started.BaselineDistance < 300 || started.BaselineDistance > 310 {
+		t.Errorf("Observe() baseline = %v spots/min, %v km, want about 2 and 300", started.BaselineRate, started.BaselineDistance)
+	}
+	if detector.states[openingKey{224, "2m", DirectionSent}].opening == nil {
+		t.Errorf("Observe() left the band closed")
+	}
+
+	minutes(75, 120, 2, 300)
+	if len(events) != 2 || events[1].Type != OpeningEnded {
+		t.Fatalf("Observe() = %v, want the opening ended", events)
+	}
+	ended := events[1]
+	if ended.Started != started.Started || ended.Ended <= ended.Started {
+		t.Errorf("Observe() = %+v, want it to end after it started at %d", ended, started.Started)
+	}
+	if ended.PeakDistance != 1509 || len(ended.Paths) != OpeningSamplePaths || ended.Paths[0].Distance != 1509 {
+		t.Errorf("Observe() peak = %d, paths = %v, want 1509 km, longest first", ended.PeakDistance, ended.Paths)
+	}
+}
+
+func TestOpeningDetectorFutureSpot(t *testing.T) {
+	config := Config{Countries: []int{224}, OpeningFactor: 3, OpeningMinSpots: 10, OpeningBaseline: time.Hour}
+	detector := NewOpeningDetector()
+	start := int64(1700000000)
+	now := time.Unix(start, 0)
+	spotClock = func() time.Time { return now }
+	defer func() { spotClock = time.Now }()
+
+	var events []OpeningEvent
+	observe := func(time uint64, distance int64) {
+		spot := &Payload{Time: time, Band: "2m", SenderCountry: 224, ReceiverCountry: 284, Distance: distance, located: true}
+		events = append(events, detector.Observe(config, spot, Classify(config, spot))...)
+	}
+	minutes := func(from int, to int, spotsPerMinute int, distance int64) {
+		for minute := from; minute < to; minute++ {
+			for i := range spotsPerMinute {
+				now = time.Unix(start+int64(minute*60+i), 0)
+				observe(uint64(now.Unix()), distance+int64(i))
+			}
+		}
+	}
+
+	// A receiver a day ahead doesn't take the detector with it, and the
+	// opening after it is still told about
+	minutes(0, 30, 2, 300)
+	observe(uint64(now.Add(24*time.Hour).Unix()), 300)
+	if got := detector.bucket; got > now.Add(MaxSpotAhead).Unix()/60 {
+		t.Errorf("Observe() moved to minute %d, want no further than %d", got, now.Add(MaxSpotAhead).Unix()/60)
+	}
+	minutes(30, 60, 2, 300)
+	minutes(60, 75, 10, 1500)
+	if len(events) != 1 || events[0].Type != OpeningStarted {
+		t.Fatalf("Observe() = %v, want an opening started", events)
+	}
+
+	// Replays keep going by the spots' time
+	config.ReplayFile = "spots.ndjson"
+	observe(uint64(now.Add(24*time.Hour).Unix()), 300)
+	if got, want := detector.bucket, now.Add(24*time.Hour).Unix()/60; got != want {
+		t.Errorf("Observe() in a replay moved to minute %d, want %d", got, want)
+	}
+}
+
+func TestOpeningDetectorQuietFeed(t *testing.T) {
+	config := Config{Countries: []int{224}, OpeningFactor: 3, OpeningMinSpots: 10, OpeningBaseline: time.Hour}
+	detector := NewOpeningDetector()
+	start := int64(1700000000)
+	now := time.Unix(start, 0)
+	spotClock = func() time.Time { return now }
+	defer func() { spotClock = time.Now }()
+
+	if events := detector.Advance(config); events != nil || detector.bucket != 0 {
+		t.Errorf("Advance() = %v, at minute %d, want nothing before the first spot", events, detector.bucket)
+	}
+
+	var events []OpeningEvent
+	for minute := range 75 {
+		spotsPerMinute, distance := 2, int64(300)
+		if minute >= 60 {
+			spotsPerMinute, distance = 10, 1500
+		}
+		for i := range spotsPerMinute {
+			now = time.Unix(start+int64(minute*60+i), 0)
+			spot := &Payload{Time: uint64(now.Unix()), Band: "2m", SenderCountry: 224, ReceiverCountry: 284, Distance: distance, located: true}
+			events = append(events, detector.Observe(config, spot, Classify(config, spot))...)
+		}
+	}
+	if len(events) != 1 || events[0].Type != OpeningStarted {
+		t.Fatalf("Observe() = %v, want an opening started", events)
+	}
+
+	// Not a spot since, yet the clock goes on, and the opening ends with it
+	now = now.Add(30 * time.Minute)
+	events = detector.Advance(config)
+	if len(events) != 1 || events[0].Type != OpeningEnded {
+		t.Fatalf("Advance() = %v, want the opening ended", events)
+	}
+	if got, want := detector.bucket, now.Add(-MaxSpotAhead).Unix()/60; got != want {
+		t.Errorf("Advance() moved to minute %d, want %d", got, want)
+	}
+
+	// Replays keep going by the spots' time
+	config.ReplayFile = "spots.ndjson"
+	now = now.Add(time.Hour)
+	if events := detector.Advance(config); events != nil {
+		t.Errorf("Advance() in a replay = %v, want nothing", events)
+	}
+}
+
+func TestAddOpeningPath(t *testing.T) {
+	var paths []OpeningPath
+	for i, distance := range []int64{100, 700, 300, 900, 500, 800, 200} {
+		paths = addOpeningPath(paths, OpeningPath{SenderCallsign: "OH2XYZ", ReceiverCallsign: string(rune('A' + i)), Distance: distance})
+	}
+	// The same pair again, shorter and then longer
+	paths = addOpeningPath(paths, OpeningPath{SenderCallsign: "OH2XYZ", ReceiverCallsign: "B", Distance: 600})
+	paths = addOpeningPath(paths, OpeningPath{SenderCallsign: "OH2XYZ", ReceiverCallsign: "C", Distance: 1000})
+
+	var distances []int64
+	for _, path := range paths {
+		distances = append(distances, path.Distance)
+	}
+	if want := []int64{1000, 900, 800, 700, 500}; !slices.Equal(distances, want) {
+		t.Errorf("addOpeningPath() = %v, want %v", distances, want)
+	}
+}
+
+func TestPostWebhooks(t *testing.T) {
+	setupMetricsOnce.Do(func() {
+		SetupMetrics(Config{})
+	})
+
+	received := make(chan OpeningEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var event OpeningEvent
+		if err := json.NewDecoder(request.Body).Decode(&event); err != nil {
+			t.Errorf("Decode() error = %v", err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	postWebhooks([]string{server.URL}, "opening", OpeningEvent{Type: OpeningStarted, Band: "6m"})
+	select {
+	case event := <-received:
+		if event.Type != OpeningStarted || event.Band != "6m" {
+			t.Errorf("webhook got %+v, want a 6m opening", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("webhook got nothing")
+	}
+	waitWebhooks()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/paulmach/orb/geojson"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+// Export the most recent of the filtered spots as great circle paths between
+// sender and receiver, for dropping into QGIS, Leaflet, and the like
+func pathsHandler(getConfig func() Config) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		config := getConfig()
+		log.Debug().Str("query", request.URL.RawQuery).Msg("Serving spot paths")
+
+		filter := NewFilter(config, request)
+		if err := filter.Err(); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := parseRange(request.URL.Query())
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Oldest first, like the rest of the API, and drawn once the index is
+		// no longer held
+		collection := geojson.NewFeatureCollection()
+		for _, spot := range spotIndex.Find(&filter, limit) {
+			if !spot.located {
+				continue
+			}
+			sender, err := locatorPoint(spot.SenderLocator)
+			if err != nil {
+				continue
+			}
+			receiver, err := locatorPoint(spot.ReceiverLocator)
+			if err != nil {
+				continue
+			}
+
+			feature := geojson.NewFeature(greatCircle(sender, receiver))
+			feature.ID = spot.SequenceNumber
+			feature.Properties = spotProperties(spot)
+			collection.Append(feature)
+		}
+
+		writer.Header().Set("Content-Type", GeojsonType)
+		if err := json.NewEncoder(writer).Encode(collection); err != nil {
+			log.Debug().Err(err).Msg("Could not write spot paths")
+		}
+	}
+}
+
+// Properties are the spot itself, keyed as it appears elsewhere in the API
+func spotProperties(spot *Payload) geojson.Properties {
+	properties := geojson.Properties{
+		"sq": spot.SequenceNumber,
+		"f":  spot.Frequency,
+		"md": spot.Mode,
+		"rp": spot.Report,
+		"t":  spot.Time,
+		"sc": spot.SenderCallsign,
+		"sl": spot.SenderLocator,
+		"rc": spot.ReceiverCallsign,
+		"rl": spot.ReceiverLocator,
+		"sa": spot.SenderCountry,
+		"ra": spot.ReceiverCountry,
+		"b":  spot.Band,
+	}
+	if spot.SequenceHex != "" {
+		properties["sequenceHex"] = spot.SequenceHex
+	}
+	if spot.Mhz != 0 {
+		properties["mhz"] = spot.Mhz
+	}
+	if spot.FormattedTime != "" {
+		properties["formattedTime"] = spot.FormattedTime
+	}
+	if spot.Distance != 0 {
+		properties["distance"] = spot.Distance
+	}
+	if spot.SenderDxcc != nil {
+		properties["senderDxcc"] = spot.SenderDxcc
+	}
+	if spot.ReceiverDxcc != nil {
+		properties["receiverDxcc"] = spot.ReceiverDxcc
+	}
+	return properties
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/paulmach/orb/geojson"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPathsHandler(t *testing.T) {
+	previous := spotIndex
+	spotIndex = NewSpotIndex()
+	defer func() { spotIndex = previous }()
+
+	for _, spot := range []*Payload{
+		{SequenceNumber: 1, Time: 100, Band: "2m", SenderCallsign: "OH1ABC", SenderLocator: "KP20", ReceiverCallsign: "DL1ABC", ReceiverLocator: "JO62"},
+		{SequenceNumber: 2, Time: 200, Band: "2m", SenderCallsign: "OH1ABC", SenderLocator: "KP20"},
+		{SequenceNumber: 3, Time: 300, Band: "2m", SenderCallsign: "OH2ABC", SenderLocator: "KP20", ReceiverCallsign: "SM1ABC", ReceiverLocator: "JO89"},
+	} {
+		locate(spot)
+		spotIndex.Insert(spot)
+	}
+	config := Config{Bands: []string{"2m"}}
+	handler := pathsHandler(func() Config { return config })
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		ids    []float64
+	}{
+		{"all", "", http.StatusOK, []float64{1, 3}},
+		{"limited", "limit=2", http.StatusOK, []float64{3}},
+		{"bad limit", "limit=0", http.StatusBadRequest, nil},
+		{"bad query", "q=band+%3D", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler(recorder, httptest.NewRequest("GET", "/api/paths?"+tt.query, nil))
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := recorder.Header().Get("Content-Type"); got != GeojsonType {
+				t.Errorf("Content-Type = %q, want %q", got, GeojsonType)
+			}
+
+			// Oldest first, and only the spots with both ends on the map
+			collection, err := geojson.UnmarshalFeatureCollection(recorder.Body.Bytes())
+			if err != nil {
+				t.Fatalf("UnmarshalFeatureCollection() error = %v", err)
+			}
+			var ids []float64
+			for _, feature := range collection.Features {
+				ids = append(ids, feature.ID.(float64))
+			}
+			if len(ids) != len(tt.ids) || (len(ids) > 0 && ids[0] != tt.ids[0]) {
+				t.Errorf("features %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest("GET", "/api/paths?limit=1", nil))
+	var collection struct {
+		Features []struct {
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&collection); err != nil || len(collection.Features) != 1 {
+		t.Fatalf("Decode() = %+v, %v, want a single feature", collection, err)
+	}
+	properties := collection.Features[0].Properties
+	if properties["sq"] != float64(3) || properties["sc"] != "OH2ABC" || properties["rl"] != "JO89" || properties["distance"] == nil {
+		t.Errorf("properties = %v, want the spot as the API has it", properties)
+	}
+}
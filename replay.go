@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SourceMqtt       = "mqtt"
+	SourceFilePrefix = "file:"
+)
+
+// RecordedMessage is one line of a recording, the message just as it came
+// from MQTT, and when
+type RecordedMessage struct {
+	Time    time.Time       `json:"time"`
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Replaying counts as being ready, there being no broker to wait for
+var replaying atomic.Bool
+
+// Recorder appends messages to a file as NDJSON, to be replayed later
+type Recorder struct {
+	path string
+	file *os.File
+	lock sync.Mutex
+}
+
+func OpenRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{path: path, file: file}, nil
+}
+
+func (recorder *Recorder) Record(topic string, raw []byte) error {
+	message := RecordedMessage{Time: time.Now().UTC(), Topic: topic, Payload: raw}
+	if !json.Valid(raw) {
+		// Keep it anyway, as a string, for the replay to trip over too
+		message.Payload, _ = json.Marshal(string(raw))
+	}
+	record, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	_, err = recorder.file.Write(append(record, '\n'))
+	return err
+}
+
+func (recorder *Recorder) Close() error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	if err := recorder.file.Sync(); err != nil {
+		recorder.file.Close()
+		return err
+	}
+	return recorder.file.Close()
+}
+
+// ReplayFile feeds recorded messages to the ingester, speed times as fast as
+// they were recorded, or as fast as they go with a speed of zero. Once done it
+// waits for the context like Subscribe does, to keep serving what was replayed.
+// Spots are moved along in time to be as old when replayed as they were when
+// recorded, so that retention and windows going by the clock keep them.
+func ReplayFile(ctx context.Context, path string, speed float64, ingester *Ingester) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", path).Msg("Could not open recording")
+	}
+	defer file.Close()
+
+	log.Info().Str("path", path).Float64("speed", speed).Msg("Replaying")
+	replaying.Store(true)
+	defer replaying.Store(false)
+
+	var first time.Time
+	started := time.Now()
+	count := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var message RecordedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			log.Warn().Err(err).Str("path", path).Int("line", line).Msg("Skipping unreadable recorded message")
+			continue
+		}
+
+		// Keep the original pacing between messages, sped up
+		if speed > 0 && !message.Time.IsZero() {
+			if first.IsZero() {
+				first = message.Time
+			}
+			due := started.Add(time.Duration(float64(message.Time.Sub(first)) / speed))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(due)):
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		var shift time.Duration
+		if !message.Time.IsZero() {
+			shift = time.Since(message.Time)
+		}
+		ingester.IngestShifted(message.Topic, message.Payload, shift)
+		count += 1
+	}
+	if err := scanner.Err(); err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Could not read recording")
+	}
+	log.Info().Str("path", path).Int("messages", count).Msg("Replay finished")
+
+	<-ctx.Done()
+}
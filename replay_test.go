@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var setupMetricsOnce sync.Once
+
+func TestRecordAndReplay(t *testing.T) {
+	setupMetricsOnce.Do(func() {
+		SetupMetrics(Config{})
+	})
+	previous := currentConfig
+	currentConfig = &Config{Countries: []int{224}, SpotsBackpressure: BackpressureBlock}
+	t.Cleanup(func() { currentConfig = previous })
+	path := filepath.Join(t.TempDir(), "recording.ndjson")
+
+	recorder, err := OpenRecorder(path)
+	if err != nil {
+		t.Fatalf("OpenRecorder() error = %v", err)
+	}
+	messages := []string{
+		`{"sq":1,"f":144174000,"md":"FT8","rp":-10,"t":1700000000,"sc":"OH2EWL","sl":"KP20","rc":"SM5X","rl":"JO89","sa":224,"ra":284,"b":"2m"}`,
+		`not json`,
+		`{"sq":1,"f":144174000,"md":"FT8","rp":-10,"t":1700000000,"sc":"OH2EWL","sl":"KP20","rc":"SM5X","rl":"JO89","sa":224,"ra":284,"b":"2m"}`,
+		`{"sq":2,"f":432174000,"md":"FT8","rp":-20,"t":1700000060,"sc":"SM5X","sl":"JO89","rc":"OH2EWL","rl":"KP20","sa":284,"ra":224,"b":"70cm"}`,
+	}
+	for _, message := range messages {
+		if err := recorder.Record("pskr/filter/v2/2m/FT8/OH2EWL/SM5X/KP20/JO89/224/284", []byte(message)); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	spots := make(chan *Payload, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	ingester := NewIngester(ctx, spots, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ReplayFile(ctx, path, 0, ingester)
+	}()
+
+	// The one that isn't JSON is skipped, and so is the duplicate
+	for _, want := range []uint64{1, 2} {
+		select {
+		case spot := <-spots:
+			if spot.SequenceNumber != want || spot.Distance == 0 {
+				t.Errorf("spot = %+v, want sequence %d with a distance", spot, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no spot %d replayed", want)
+		}
+	}
+
+	cancel()
+	<-done
+	ingester.Stop()
+	if len(spots) != 0 {
+		t.Errorf("%d extra spots replayed", len(spots))
+	}
+}
+
+func TestReplayShiftsTime(t *testing.T) {
+	setupMetricsOnce.Do(func() {
+		SetupMetrics(Config{})
+	})
+	previous := currentConfig
+	currentConfig = &Config{Countries: []int{224}, SpotsBackpressure: BackpressureBlock}
+	t.Cleanup(func() { currentConfig = previous })
+
+	// Received five seconds after the spot, years ago
+	path := filepath.Join(t.TempDir(), "recording.ndjson")
+	recording := `{"time":"2023-11-14T22:13:25Z","topic":"pskr/filter/v2/2m/FT8/OH2EWL/SM5X/KP20/JO89/224/284","payload":{"sq":1,"f":144174000,"md":"FT8","rp":-10,"t":1700000000,"sc":"OH2EWL","sl":"KP20","rc":"SM5X","rl":"JO89","sa":224,"ra":284,"b":"2m"}}` + "\n"
+	if err := os.WriteFile(path, []byte(recording), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	spots := make(chan *Payload, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	ingester := NewIngester(ctx, spots, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ReplayFile(ctx, path, 1, ingester)
+	}()
+
+	select {
+	case spot := <-spots:
+		age := time.Since(time.Unix(int64(spot.Time), 0))
+		if age < 4*time.Second || age > 7*time.Second {
+			t.Errorf("replayed spot is %v old, want about 5s", age)
+		}
+		if want := time.Unix(int64(spot.Time), 0).UTC().Format(TimeFormat); spot.FormattedTime != want {
+			t.Errorf("FormattedTime = %q, want %q", spot.FormattedTime, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no spot replayed")
+	}
+
+	cancel()
+	<-done
+	ingester.Stop()
+}
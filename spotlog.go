@@ -2,13 +2,17 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/rs/zerolog/log"
 	"io"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"slices"
-	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"text/template"
 	"time"
@@ -17,29 +21,78 @@ import (
 type Streamer struct {
 	Keepalive time.Time
 	Spots     chan *Payload
+	Openings  chan OpeningEvent
+	Watches   chan WatchEvent
 }
 
-const SpotlogPruneInterval = time.Second * 90
+const (
+	SpotlogPruneInterval = time.Second * 90
+	StreamFormatJson     = "json"
+	StreamReplayLimit    = 1000
+	StreamEventShutdown  = "shutdown"
+	StreamEventOpening   = "opening"
+	StreamShutdownRetry  = time.Second * 30
+	DefaultPageLimit     = 200
+	MaxPageLimit         = 2000
+)
 
 var (
-	Spots            []*Payload
-	SpotLock         sync.Mutex
 	Streamers        map[uint64]*Streamer
 	StreamLock       sync.Mutex
+	streaming        sync.WaitGroup
+	spotlogStopping  = make(chan struct{})
+	spotJournal      *SpotJournal
 	pageTemplate     *template.Template
 	tablerowTemplate *template.Template
 )
 
+// Spotlog keeps and serves spots until the channel is closed, and then, with
+// every last spot in, shuts down the server and closes the store
 func Spotlog(config Config, spots <-chan *Payload) {
 	Streamers = make(map[uint64]*Streamer)
-	go serveSpotlog(config)
-	go pruneSpotlogSpots(config)
 
-	for spot := range spots {
+	if config.SpotlogStore != "" {
+		var err error
+		if spotJournal, err = OpenSpotJournal(config.SpotlogStore); err != nil {
+			log.Fatal().Err(err).Str("path", config.SpotlogStore).Msg("Could not open spotlog store")
+		}
+		cutoff := uint64(time.Now().UTC().Add(-config.SpotlogRetention).Unix())
+		replayed, err := spotJournal.Replay(cutoff)
+		if err != nil {
+			log.Fatal().Err(err).Str("path", config.SpotlogStore).Msg("Could not replay spotlog store")
+		}
+		for _, spot := range replayed {
+			spotIndex.Insert(spot)
+		}
+	}
+
+	server := serveSpotlog(config)
+
+	// Pruning happens in between spots, so that the journal never sees a spot
+	// both appended and compacted
+	pruning := time.NewTicker(SpotlogPruneInterval)
+	defer pruning.Stop()
+
+	for {
+		var spot *Payload
+		select {
+		case <-pruning.C:
+			pruneSpotlogSpots()
+			continue
+		case spot = <-spots:
+		}
+		// Closed, and drained
+		if spot == nil {
+			break
+		}
+
 		log.Debug().Any("payload", spot).Msg("Spotlogging")
-		SpotLock.Lock()
-		Spots = append(Spots, spot)
-		SpotLock.Unlock()
+		spotIndex.Insert(spot)
+		if spotJournal != nil {
+			if err := spotJournal.Append(spot); err != nil {
+				log.Error().Err(err).Msg("Could not append spot to spotlog store")
+			}
+		}
 
 		StreamLock.Lock()
 		log.Debug().Int("streamers", len(Streamers)).Msg("Feeding to streamers")
@@ -59,44 +112,50 @@ func Spotlog(config Config, spots <-chan *Payload) {
 		}
 		StreamLock.Unlock()
 	}
-}
 
-func getSpotlogSpots() []*Payload {
-	SpotLock.Lock()
-	sort.Slice(Spots, func(i, j int) bool {
-		return Spots[i].Time < Spots[j].Time
-	})
-	spots := make([]*Payload, len(Spots))
-	copy(spots, Spots)
-	SpotLock.Unlock()
+	log.Info().Msg("Spots drained, stopping spotlog")
+	shutdownServer(server)
+
+	// The server doesn't wait for WebSockets, as they've been hijacked from it
+	streamed := make(chan struct{})
+	go func() {
+		streaming.Wait()
+		close(streamed)
+	}()
+	select {
+	case <-streamed:
+	case <-time.After(ShutdownTimeout):
+		log.Warn().Msg("Gave up waiting for streamers")
+	}
 
-	return spots
+	if spotJournal != nil {
+		if err := spotJournal.Close(); err != nil {
+			log.Error().Err(err).Msg("Could not close spotlog store")
+		}
+	}
 }
 
-func pruneSpotlogSpots(config Config) {
-	ticker := time.NewTicker(SpotlogPruneInterval)
-
-	// FIXME once table is in order, could we simply truncate and avoid copying anything
-	for {
-		select {
-		case <-ticker.C:
-			log.Debug().Dur("retention", config.SpotlogRetention).Msg("Pruning spotlog spots")
-			retainedSpots := make([]*Payload, 0)
-			cutoff := uint64(time.Now().UTC().Add(-config.SpotlogRetention).Unix())
-			SpotLock.Lock()
-			for _, retained := range Spots {
-				if retained.Time >= cutoff {
-					retainedSpots = append(retainedSpots, retained)
-				}
-			}
-			Spots = make([]*Payload, len(retainedSpots))
-			copy(Spots, retainedSpots)
-			SpotLock.Unlock()
+// Let go of the spots past retention, and keep whatever else goes by the clock
+// moving along while the feed is quiet
+func pruneSpotlogSpots() {
+	config := CurrentConfig()
+	log.Debug().Dur("retention", config.SpotlogRetention).Msg("Pruning spotlog spots")
+	cutoff := uint64(time.Now().UTC().Add(-config.SpotlogRetention).Unix())
+	retained := spotIndex.Prune(cutoff)
+	if active_stations != nil {
+		active_stations.Prune(time.Now())
+	}
+	if opening_detector != nil {
+		for _, event := range opening_detector.Advance(config) {
+			announceOpening(config, event)
 		}
 	}
+	if spotJournal != nil {
+		spotJournal.Compact(retained, func() []*Payload { return spotIndex.Find(&Filter{}, 0) })
+	}
 }
 
-func serveSpotlog(config Config) {
+func serveSpotlog(config Config) *http.Server {
 	var err error
 
 	pageTemplate, err = template.New("page").Parse(pageHtml)
@@ -109,14 +168,42 @@ func serveSpotlog(config Config) {
 		log.Fatal().Err(err).Msg("Failed to parse tablerow template")
 	}
 
+	mapTemplate, err = template.New("map").Parse(mapHtml)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to parse map template")
+	}
+
 	log.Debug().Any("page", pageTemplate).Any("tablerow", tablerowTemplate).Msg("Templates parsed")
 
 	spotlogMux := http.NewServeMux()
-	spotlogMux.HandleFunc("GET /", pageHandler(config))
+	spotlogMux.HandleFunc("GET /", pageHandler(CurrentConfig))
 	spotlogMux.HandleFunc("GET /favicon.ico", faviconHandler)
 	spotlogMux.HandleFunc("GET /robots.txt", robotstxtHandler)
-	spotlogMux.HandleFunc("GET /stream/", streamHandler(config))
-	log.Fatal().Err(http.ListenAndServe(config.SpotlogAddrPort, spotlogMux)).Send()
+	spotlogMux.HandleFunc("GET /map", mapHandler(CurrentConfig))
+	spotlogMux.HandleFunc("GET /map/world.json", worldHandler)
+	spotlogMux.HandleFunc("GET /stream/", streamHandler(CurrentConfig))
+	spotlogMux.HandleFunc("GET /stream/watches", watchStreamHandler)
+	spotlogMux.HandleFunc("GET /ws/", websocketHandler(CurrentConfig))
+	spotlogMux.HandleFunc("GET /api/spots", spotsApiHandler(CurrentConfig))
+	spotlogMux.HandleFunc("GET /api/heatmap", heatmapHandler(CurrentConfig))
+	spotlogMux.HandleFunc("GET /api/paths", pathsHandler(CurrentConfig))
+	spotlogMux.HandleFunc("GET /api/watches", watchesHandler(CurrentConfig))
+	spotlogMux.HandleFunc("PUT /api/watches/{name}", putWatchHandler(CurrentConfig))
+	spotlogMux.HandleFunc("DELETE /api/watches/{name}", deleteWatchHandler(CurrentConfig))
+
+	server := &http.Server{Addr: config.SpotlogAddrPort, Handler: spotlogMux}
+	server.RegisterOnShutdown(func() {
+		StreamLock.Lock()
+		close(spotlogStopping)
+		StreamLock.Unlock()
+	})
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal().Err(err).Str("addrport", config.SpotlogAddrPort).Msg("Could not serve spotlog")
+		}
+	}()
+
+	return server
 }
 
 func faviconHandler(writer http.ResponseWriter, request *http.Request) {
@@ -128,8 +215,9 @@ func robotstxtHandler(writer http.ResponseWriter, request *http.Request) {
 	io.WriteString(writer, "User-agent: *\nDisallow:\n")
 }
 
-func pageHandler(config Config) func(http.ResponseWriter, *http.Request) {
+func pageHandler(getConfig func() Config) func(http.ResponseWriter, *http.Request) {
 	return func(writer http.ResponseWriter, request *http.Request) {
+		config := getConfig()
 
 		if request.URL.Path != "/" {
 			writer.WriteHeader(http.StatusNotFound)
@@ -139,13 +227,32 @@ func pageHandler(config Config) func(http.ResponseWriter, *http.Request) {
 		log.Debug().Msg("Serving a page")
 
 		filter := NewFilter(config, request)
+		if err := filter.Err(); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query := request.URL.Query()
+		limit, cursor, err := parsePage(query)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
 		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
 
+		// One more than fits on the page tells whether there's an older one
+		page := findPage(filter, cursor, limit+1)
+		var older string
+		if len(page) > limit {
+			page = page[:limit]
+			last := page[limit-1]
+			query.Set("before", PageCursor{Time: last.Time, Sequence: last.SequenceNumber}.String())
+			older = "/?" + query.Encode()
+		}
+		query.Del("before")
+		newest := "/?" + query.Encode()
+
 		var tablerows []string
-		for _, spot := range slices.Backward(getSpotlogSpots()) {
-			if filter.Enabled && !filter.filter(*spot) {
-				continue
-			}
+		for _, spot := range page {
 			var row bytes.Buffer
 			if err := tablerowTemplate.Execute(&row, spot); err != nil {
 				log.Error().Err(err).Msg("Could not render table row template")
@@ -158,96 +265,287 @@ func pageHandler(config Config) func(http.ResponseWriter, *http.Request) {
 			Config    Config
 			Filter    Filter
 			Tablerows []string
+			Older     string
+			Newest    string
+			Before    bool
+			Watching  bool
 		}{
 			Config:    config,
 			Filter:    filter,
 			Tablerows: tablerows,
+			Older:     older,
+			Newest:    newest,
+			Before:    cursor.Time != 0,
+			Watching:  watchList != nil && watchList.Len() > 0,
 		}); err != nil {
 			log.Error().Err(err).Msg("Failed to render page template")
 		}
 	}
 }
 
-func streamHandler(config Config) func(http.ResponseWriter, *http.Request) {
+// PageCursor is where an older page starts: right after the spot with the
+// given time and sequence number, or with the spots before the given time,
+// when there's no sequence number
+type PageCursor struct {
+	Time     uint64
+	Sequence uint64
+}
+
+func (cursor PageCursor) String() string {
+	return fmt.Sprintf("%d-%d", cursor.Time, cursor.Sequence)
+}
+
+func parsePageCursor(value string) (cursor PageCursor, ok bool) {
+	seconds, sequence, ok := strings.Cut(value, "-")
+	if !ok {
+		return cursor, false
+	}
+	var timeErr, sequenceErr error
+	cursor.Time, timeErr = strconv.ParseUint(seconds, 10, 64)
+	cursor.Sequence, sequenceErr = strconv.ParseUint(sequence, 10, 64)
+	if timeErr != nil || sequenceErr != nil {
+		return PageCursor{}, false
+	}
+	return cursor, true
+}
+
+// Parse the limit, and where to start, as either a cursor from an older link,
+// or a timestamp
+func parsePage(query url.Values) (limit int, cursor PageCursor, err error) {
+	limit = DefaultPageLimit
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			return 0, cursor, fmt.Errorf("limit: not a positive integer: %q", value)
+		}
+		limit = min(limit, MaxPageLimit)
+	}
+
+	value := query.Get("before")
+	if cursor, ok := parsePageCursor(value); ok {
+		return limit, cursor, nil
+	}
+	if cursor.Time, err = parseTimestamp(value); err != nil {
+		return 0, cursor, fmt.Errorf("before: %w", err)
+	}
+
+	return limit, cursor, nil
+}
+
+// The newest limit spots older than the cursor, newest first
+func findPage(filter Filter, cursor PageCursor, limit int) []*Payload {
+	if cursor.Time != 0 {
+		until := cursor.Time
+		if cursor.Sequence == 0 {
+			until -= 1
+		}
+		if filter.Until == 0 || until < filter.Until {
+			filter.Until = until
+		}
+	}
+
+	// Spots of the cursor's second come first, and are skipped up to and
+	// including the one it points at
+	skipping := cursor.Sequence != 0
+	page := make([]*Payload, 0, limit)
+	for spot := range spotIndex.Newest(&filter) {
+		if skipping && spot.Time == cursor.Time {
+			if spot.SequenceNumber == cursor.Sequence {
+				skipping = false
+			}
+			continue
+		}
+		skipping = false
+		if len(page) == limit {
+			break
+		}
+		page = append(page, spot)
+	}
+
+	return page
+}
+
+func addStreamer() (uint64, *Streamer, bool) {
+	return registerStreamer(&Streamer{
+		Spots:    make(chan *Payload, 1000),
+		Openings: make(chan OpeningEvent, 16),
+	})
+}
+
+// Streamers only get what they have a channel for, sends to the others
+// falling through. None are taken once stopping, so that none are added while
+// shutting down waits for the ones there are.
+func registerStreamer(streamer *Streamer) (uint64, *Streamer, bool) {
+	id := rand.Uint64()
+	streamer.Keepalive = time.Now()
+
+	StreamLock.Lock()
+	defer StreamLock.Unlock()
+	select {
+	case <-spotlogStopping:
+		return 0, nil, false
+	default:
+	}
+	log.Debug().Uint64("id", id).Msg("Adding streamer")
+	Streamers[id] = streamer
+	streaming.Add(1)
+
+	return id, streamer, true
+}
+
+func removeStreamer(id uint64) {
+	StreamLock.Lock()
+	log.Debug().Uint64("id", id).Msg("Removing streamer")
+	delete(Streamers, id)
+	StreamLock.Unlock()
+	streaming.Done()
+}
+
+// Write a spot as a server-sent event, either as a table row or as JSON
+func writeSpotEvent(writer io.Writer, format string, spot *Payload) {
+	var data []byte
+	if format == StreamFormatJson {
+		var err error
+		if data, err = json.Marshal(spot); err != nil {
+			log.Error().Err(err).Msg("Could not marshal spot")
+			return
+		}
+	} else {
+		var row bytes.Buffer
+		if err := tablerowTemplate.Execute(&row, spot); err != nil {
+			log.Error().Err(err).Msg("Could not render table row template")
+			return
+		}
+		data = row.Bytes()
+	}
+	io.WriteString(writer, fmt.Sprintf("id: %d\ndata: %s\n\n", spot.SequenceNumber, data))
+}
+
+func streamHandler(getConfig func() Config) func(http.ResponseWriter, *http.Request) {
 	return func(writer http.ResponseWriter, request *http.Request) {
+		config := getConfig()
 		log.Debug().Msg("Streaming spots")
 		filter := NewFilter(config, request)
+		if err := filter.Err(); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format := request.URL.Query().Get("format")
 
-		id := rand.Uint64()
-		StreamLock.Lock()
-		log.Debug().Uint64("id", id).Msg("Adding streamer")
-		Streamers[id] = &Streamer{
-			Keepalive: time.Now(),
-			Spots:     make(chan *Payload, 1000),
+		id, streamer, ok := addStreamer()
+		if !ok {
+			http.Error(writer, "shutting down", http.StatusServiceUnavailable)
+			return
 		}
-		StreamLock.Unlock()
+		defer removeStreamer(id)
 
 		writer.Header().Set("Content-Type", "text/event-stream")
 		writer.Header().Set("Cache-Control", "no-cache")
 		writer.Header().Set("Connection", "keep-alive")
 		io.WriteString(writer, ": keepalive\n\n")
+
+		// A reconnecting client gets the retained spots it missed, and those may
+		// also turn up in the streamer's channel for a moment
+		var replayed map[uint64]bool
+		if lastEventId, err := strconv.ParseUint(request.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+			replayed = make(map[uint64]bool)
+			// Sequence numbers go by arrival, so the missed spots are the ones
+			// newer than the first the client has seen already, give or take
+			// the spots arriving out of order
+			var missed []*Payload
+			var seen uint64
+			reorder := uint64(SpotIndexReorderWindow.Seconds())
+			for spot := range spotIndex.Newest(&filter) {
+				if len(missed) == StreamReplayLimit || seen != 0 && spot.Time+reorder < seen {
+					break
+				}
+				if spot.SequenceNumber > lastEventId {
+					missed = append(missed, spot)
+				} else if seen == 0 {
+					seen = spot.Time
+				}
+			}
+			slices.Reverse(missed)
+			log.Debug().Uint64("id", id).Uint64("last", lastEventId).Int("missed", len(missed)).Msg("Replaying spots to streamer")
+			for _, spot := range missed {
+				replayed[spot.SequenceNumber] = true
+				writeSpotEvent(writer, format, spot)
+			}
+		}
+
 		if flusher, ok := writer.(http.Flusher); ok {
 			flusher.Flush()
 		}
 
 		keepalive := time.NewTicker(25 * time.Second)
+		defer keepalive.Stop()
 		update := time.NewTicker(333 * time.Millisecond)
+		defer update.Stop()
+
+		// Write whatever has queued up since the last time
+		flush := func() {
+			written := false
+			for {
+				select {
+				case spot := <-streamer.Spots:
+					if filter.Enabled && !filter.filter(spot) {
+						continue
+					}
+					if replayed[spot.SequenceNumber] {
+						continue
+					}
+					writeSpotEvent(writer, format, spot)
+					written = true
+					continue
+				default:
+				}
+				break
+			}
+			replayed = nil
+			if written {
+				if flusher, ok := writer.(http.Flusher); ok {
+					flusher.Flush()
+				}
+			}
+		}
 
 		for {
-			done := false
 			select {
 			case <-request.Context().Done():
-				log.Debug().Uint64("id", id).Msg("Streamer is gone, removing")
-				StreamLock.Lock()
-				delete(Streamers, id)
-				StreamLock.Unlock()
-				done = true
-				break
+				log.Debug().Uint64("id", id).Msg("Streamer is gone")
+				return
+			case <-spotlogStopping:
+				// Last spots first, then let the client know not to hurry back
+				flush()
+				io.WriteString(writer, fmt.Sprintf("retry: %d\nevent: %s\ndata: Server shutting down\n\n", StreamShutdownRetry.Milliseconds(), StreamEventShutdown))
+				if flusher, ok := writer.(http.Flusher); ok {
+					flusher.Flush()
+				}
+				log.Debug().Uint64("id", id).Msg("Streamer told about shutdown")
+				return
 			case <-keepalive.C:
 				StreamLock.Lock()
-				Streamers[id].Keepalive = time.Now()
+				streamer.Keepalive = time.Now()
 				StreamLock.Unlock()
 				io.WriteString(writer, ": keepalive\n\n")
 				if flusher, ok := writer.(http.Flusher); ok {
 					flusher.Flush()
 				}
 			case <-update.C:
-				var spots []*Payload
-
-				StreamLock.Lock()
-				for {
-					updated := false
-					select {
-					case spot := <-Streamers[id].Spots:
-						if filter.Enabled && !filter.filter(*spot) {
-							continue
-						}
-						spots = append(spots, spot)
-					default:
-						updated = true
-					}
-					if updated {
-						break
-					}
+				flush()
+			case event := <-streamer.Openings:
+				// Spots of other bands are filtered out, so their openings are too
+				if filter.Bands != nil && !slices.Contains(filter.Bands, event.Band) {
+					continue
 				}
-				StreamLock.Unlock()
-
-				if len(spots) > 0 {
-					for _, spot := range spots {
-						var row bytes.Buffer
-						if err := tablerowTemplate.Execute(&row, spot); err != nil {
-							log.Error().Err(err).Msg("Could not render table row template")
-						} else {
-							io.WriteString(writer, fmt.Sprintf("data: %s\n\n", row.String()))
-						}
-					}
-					if flusher, ok := writer.(http.Flusher); ok {
-						flusher.Flush()
-					}
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Error().Err(err).Msg("Could not marshal opening")
+					continue
+				}
+				io.WriteString(writer, fmt.Sprintf("event: %s\ndata: %s\n\n", StreamEventOpening, data))
+				if flusher, ok := writer.(http.Flusher); ok {
+					flusher.Flush()
 				}
-			}
-			if done {
-				break
 			}
 		}
 	}
@@ -259,17 +557,12 @@ const pageHtml = `<!DOCTYPE html>
 		<meta charset="utf-8">
 		<meta name="viewport" content="width=device-width, initial-scale=1">
 		<meta name="author" content="Joni OH2EWL">
-		<meta name="description" content="Live view of PSK Reporter's spots from and to country {{.Config.Country}}">
+		<meta name="description" content="Live view of PSK Reporter's spots from and to {{range $i, $country := .Config.Countries}}{{if $i}}, {{end}}{{$country}}{{end}}">
 		<title>
 		Spotlog
-		{{range .Filter.Bands}}
-		{{.}}
+		{{range .Filter.Terms}}
+		{{html .}}
 		{{end}}
-		{{range .Filter.Modes}}
-		{{.}}
-		{{end}}
-		{{.Filter.Locator}}
-		{{.Filter.Callsign}}
 		</title>
 		<style>
 		body {
@@ -308,15 +601,19 @@ const pageHtml = `<!DOCTYPE html>
 		</p>
 
 		<p>
-			Recording country
-			<strong>{{.Config.Country}}</strong>
+			Recording
+			{{if gt (len .Config.Countries) 1}}countries{{else}}country{{end}}
+			{{range .Config.Countries}}
+			<strong>{{.}}</strong>
+			{{end}}
 			on
 			{{range .Config.Bands}}
 			<strong>{{.}}</strong>
 			{{end}}
 			with
 			<strong>{{.Config.SpotlogRetention.String}}</strong>
-			retention
+			retention,
+			also on a <a id="map" href="/map">map</a>
 		</p>
 
 		<details style="margin-bottom: 0.65em;">
@@ -330,6 +627,18 @@ const pageHtml = `<!DOCTYPE html>
 					<tr><td>modes</td><td>modes=FT8,FT4</td><td>Match list exactly</td></tr>
 					<tr><td>locator</td><td>locator=KP20</td><td>Match prefix</td></tr>
 					<tr><td>callsign</td><td>callsign=OH2</td><td>Match prefix</td></tr>
+					<tr><td>sender_locator, receiver_locator</td><td>sender_locator=KP20</td><td>Match prefix, that end only</td></tr>
+					<tr><td>sender_callsign, receiver_callsign</td><td>receiver_callsign=OH6</td><td>Match prefix, that end only</td></tr>
+					<tr><td>direction</td><td>direction=sent,local</td><td>Match list exactly, relative to the recorded countries</td></tr>
+					<tr><td>min_distance, max_distance</td><td>min_distance=500</td><td>Kilometers, inclusive</td></tr>
+					<tr><td>min_report, max_report</td><td>min_report=-15</td><td>Decibels, inclusive</td></tr>
+					<tr><td>min_freq, max_freq</td><td>min_freq=144.170&max_freq=144.180</td><td>Megahertz, inclusive</td></tr>
+					<tr><td>since, until</td><td>since=2024-06-01T00:00:00Z</td><td>Unix seconds or RFC 3339, inclusive</td></tr>
+					<tr><td>continent</td><td>continent=EU,AS</td><td>Match list exactly, either end</td></tr>
+					<tr><td>entity</td><td>entity=Sweden,224</td><td>Match DXCC entity name or ADIF code, either end</td></tr>
+					<tr><td>limit</td><td>limit=50</td><td>Spots per page, 200 by default, at most 2000</td></tr>
+					<tr><td>before</td><td>before=2024-06-01T00:00:00Z</td><td>Unix seconds or RFC 3339, or a cursor from the older link</td></tr>
+					<tr><td>q</td><td>q=band in (2m,70cm) and (distance &gt; 500 or mode = MSK144)</td><td>Expression, on top of the above; see the README</td></tr>
 				</tbody>
 			</table>
 			<p>
@@ -338,6 +647,19 @@ const pageHtml = `<!DOCTYPE html>
 				<a href="/?bands=2m,70cm&modes=FT8">?bands=2m,70cm&modes=FT8</a>
 				<a href="/?modes=FT4,WSPR&locator=KP20&callsign=OH2">?modes=FT4,WSPR&locator=KP20&callsign=OH2</a>
 				<a href="/?callsign=OH2EWL">?callsign=OH2EWL</a>
+				<a href="/?bands=6m&continent=NA">?bands=6m&continent=NA</a>
+				<a href="/?direction=sent&min_distance=1000">?direction=sent&min_distance=1000</a>
+			</p>
+			<p>
+				The same parameters, plus <em>limit</em> and <em>cursor</em>,
+				work for <a href="/api/spots">/api/spots</a>, and adding <em>format=json</em>
+				to <a href="/stream/?format=json">/stream/</a> gives a stream of JSON spots.
+				<a href="/api/heatmap?precision=4&window=1h">/api/heatmap</a> counts spots by grid square,
+				with <em>precision</em> (2, 4, or 6), <em>window</em>, and <em>format=geojson</em>.
+				<a href="/api/paths">/api/paths</a> exports spots as GeoJSON paths between the stations.
+				Over a WebSocket at /ws/, the filter can be changed on the fly by sending
+				the parameters as a JSON object.
+				Spots matching the watch list come as events from <a href="/stream/watches">/stream/watches</a>.
 			</p>
 		</details>
 
@@ -345,18 +667,19 @@ const pageHtml = `<!DOCTYPE html>
 		<p>
 			Filter
 			<strong>
-			{{range .Filter.Bands}}
-			{{.}}
-			{{end}}
-			{{range .Filter.Modes}}
-			{{.}}
+			{{range .Filter.Terms}}
+			{{html .}}
 			{{end}}
-			{{.Filter.Locator}}
-			{{.Filter.Callsign}}
 			</strong>
 		<p>
 		{{end}}
 
+		{{if .Watching}}
+		<p><button id="notify">Notify me of watched spots</button></p>
+		{{end}}
+
+		<ul id="openings"></ul>
+
 		<table>
 			<thead>
 				<tr>
@@ -380,8 +703,15 @@ const pageHtml = `<!DOCTYPE html>
 			</tbody>
 		</table>
 
+		<p>
+			{{if .Before}}<a href="{{html .Newest}}">Newest</a>{{end}}
+			{{if .Older}}<a id="older" href="{{html .Older}}">Older</a>{{end}}
+		</p>
+
 		<script>
+		document.getElementById('map').href = '/map' + window.location.search;
 		const table = document.getElementById('spots');
+		{{if not .Before}}
 		const spots = new EventSource('/stream/' + window.location.search);
 		spots.onmessage = function(spot) {
 			console.log(spot);
@@ -389,9 +719,78 @@ const pageHtml = `<!DOCTYPE html>
 			template.innerHTML = spot.data;
 			table.prepend(template.content.firstElementChild);
 		};
+		spots.addEventListener('opening', function(message) {
+			const opening = JSON.parse(message.data);
+			const time = new Date(1000 * (opening.ended || opening.started)).toISOString().substring(11, 16);
+			const path = opening.paths.length > 0 ? ', e.g. ' + opening.paths[0].sc + ' to ' + opening.paths[0].rc : '';
+			const item = document.createElement('li');
+			item.textContent = time + ' ' + opening.band + ' ' + opening.direction + ' (' + opening.country + ') ' +
+				(opening.type === 'opening_started' ? 'opened' : 'closed') + ', ' + opening.rate.toFixed(1) + ' spots/min, peak ' +
+				opening.peak_distance + ' km' + path;
+			document.getElementById('openings').prepend(item);
+		});
+		{{end}}
+
+		{{if .Watching}}
+		// Watched spots as browser notifications, once allowed
+		const notify = document.getElementById('notify');
+		notify.addEventListener('click', async function() {
+			if (await Notification.requestPermission() !== 'granted') {
+				return;
+			}
+			notify.remove();
+			const watched = new EventSource('/stream/watches');
+			watched.addEventListener('watch', function(message) {
+				const event = JSON.parse(message.data);
+				const spot = event.spot;
+				new Notification(event.watch, {
+					body: spot.b + ' ' + spot.md + ' ' + spot.sc + ' (' + spot.sl + ') to ' + spot.rc + ' (' + spot.rl + '), ' +
+						spot.rp + ' dB' + (spot.distance ? ', ' + spot.distance + ' km' : ''),
+					tag: event.watch + ' ' + spot.b,
+				});
+			});
+		});
+		{{end}}
+
+		// Older pages get appended in place, once scrolled to or clicked
+		const older = document.getElementById('older');
+		let loading = false;
+		async function loadOlder() {
+			if (loading || !older.isConnected) {
+				return;
+			}
+			loading = true;
+			try {
+				const response = await fetch(older.getAttribute('href'));
+				if (!response.ok) {
+					return;
+				}
+				const page = new DOMParser().parseFromString(await response.text(), 'text/html');
+				table.append(...page.getElementById('spots').children);
+				const next = page.getElementById('older');
+				if (next) {
+					older.setAttribute('href', next.getAttribute('href'));
+				} else {
+					older.remove();
+				}
+			} finally {
+				loading = false;
+			}
+		}
+		if (older) {
+			older.addEventListener('click', function(event) {
+				event.preventDefault();
+				loadOlder();
+			});
+			new IntersectionObserver(function(entries) {
+				if (entries.some(entry => entry.isIntersecting)) {
+					loadOlder();
+				}
+			}).observe(older);
+		}
 		</script>
 	</body>
 </html>
 `
 
-const tablerowHtml = `<tr><td>{{.SequenceHex}}</td><td>{{.FormattedTime}}</td><td>{{.Band}}</td><td>{{.Mode}}</td><td style="text-align: center;">{{.Report}}</td><td style="text-align: right;">{{.Distance}}</td><td style="text-align: right;">{{printf "%.6f" .Mhz}}</td><td>{{.SenderCallsign}}</td><td>{{.SenderLocator}}</td><td style="text-align: center;">{{.SenderCountry}}</td><td>{{.ReceiverCallsign}}</td><td>{{.ReceiverLocator}}</td><td style="text-align: center;">{{.ReceiverCountry}}</td></tr>`
+const tablerowHtml = `<tr><td>{{.SequenceHex}}</td><td>{{.FormattedTime}}</td><td>{{.Band}}</td><td>{{.Mode}}</td><td style="text-align: center;">{{.Report}}</td><td style="text-align: right;">{{.Distance}}</td><td style="text-align: right;">{{printf "%.6f" .Mhz}}</td><td>{{.SenderCallsign}}</td><td>{{.SenderLocator}}</td><td style="text-align: center;">{{with .SenderDxcc}}<span title="ADIF {{.Adif}}, CQ zone {{.CqZone}}, ITU zone {{.ItuZone}}">{{html .Name}} <small>{{.Continent}} {{.CqZone}}/{{.ItuZone}}</small></span>{{else}}{{.SenderCountry}}{{end}}</td><td>{{.ReceiverCallsign}}</td><td>{{.ReceiverLocator}}</td><td style="text-align: center;">{{with .ReceiverDxcc}}<span title="ADIF {{.Adif}}, CQ zone {{.CqZone}}, ITU zone {{.ItuZone}}">{{html .Name}} <small>{{.Continent}} {{.CqZone}}/{{.ItuZone}}</small></span>{{else}}{{.ReceiverCountry}}{{end}}</td></tr>`
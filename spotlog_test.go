@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamHandlerShutdown(t *testing.T) {
+	Streamers = make(map[uint64]*Streamer)
+	spotlogStopping = make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(streamHandler(func() Config { return Config{} })))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/stream/?format=json")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer response.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	// Wait for the stream to be under way before queueing and stopping
+	if line := <-lines; line != ": keepalive" {
+		t.Fatalf("first line = %q, want keepalive", line)
+	}
+	StreamLock.Lock()
+	for _, streamer := range Streamers {
+		streamer.Spots <- &Payload{SequenceNumber: 1}
+	}
+	StreamLock.Unlock()
+	close(spotlogStopping)
+
+	var received []string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				stream := strings.Join(received, "\n")
+				if !strings.Contains(stream, "id: 1") {
+					t.Errorf("stream = %q, want the queued spot", stream)
+				}
+				if !strings.HasSuffix(stream, "event: "+StreamEventShutdown+"\ndata: Server shutting down") {
+					t.Errorf("stream = %q, want a shutdown event last", stream)
+				}
+				return
+			}
+			if line != "" {
+				received = append(received, line)
+			}
+		case <-timeout:
+			t.Fatalf("stream didn't end, got %q", received)
+		}
+	}
+}
+
+func TestStreamHandlerStopping(t *testing.T) {
+	Streamers = make(map[uint64]*Streamer)
+	spotlogStopping = make(chan struct{})
+	close(spotlogStopping)
+
+	for _, handler := range []http.HandlerFunc{streamHandler(func() Config { return Config{} }), websocketHandler(func() Config { return Config{} }), watchStreamHandler} {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest("GET", "/stream/", nil))
+		if recorder.Code != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+		}
+	}
+	if len(Streamers) != 0 {
+		t.Errorf("%d streamers added while stopping", len(Streamers))
+	}
+}
+
+func TestStreamHandlerReplay(t *testing.T) {
+	previous := spotIndex
+	defer func() { spotIndex = previous }()
+
+	// Stream with the given Last-Event-ID, queue the live spots once the
+	// replay is through, and tell the ids in the stream
+	stream := func(lastEventId string, live ...*Payload) []string {
+		Streamers = make(map[uint64]*Streamer)
+		spotlogStopping = make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(streamHandler(func() Config { return Config{} })))
+		defer server.Close()
+
+		request, _ := http.NewRequest("GET", server.URL+"/stream/?format=json", nil)
+		request.Header.Set("Last-Event-ID", lastEventId)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		defer response.Body.Close()
+
+		var ids []string
+		queued := false
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == ": keepalive" && !queued {
+				StreamLock.Lock()
+				for _, streamer := range Streamers {
+					for _, spot := range live {
+						streamer.Spots <- spot
+					}
+				}
+				StreamLock.Unlock()
+				close(spotlogStopping)
+				queued = true
+			}
+			if id, ok := strings.CutPrefix(line, "id: "); ok {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+
+	// Ten seconds apart, with one spot a few minutes late and another too
+	// late to count as missed
+	spotIndex = NewSpotIndex()
+	for sequence := uint64(1); sequence <= 10; sequence++ {
+		spotIndex.Insert(&Payload{SequenceNumber: sequence, Time: 10000 + sequence*10})
+	}
+	spotIndex.Insert(&Payload{SequenceNumber: 11, Time: 9000})
+	spotIndex.Insert(&Payload{SequenceNumber: 12, Time: 9870})
+
+	if got, want := stream("7"), []string{"12", "8", "9", "10"}; !slices.Equal(got, want) {
+		t.Errorf("replay after 7 = %v, want %v", got, want)
+	}
+	if got, want := stream("10"), []string{"12"}; !slices.Equal(got, want) {
+		t.Errorf("replay after 10 = %v, want %v", got, want)
+	}
+	if got, want := stream("8", &Payload{SequenceNumber: 10}, &Payload{SequenceNumber: 13}), []string{"12", "9", "10", "13"}; !slices.Equal(got, want) {
+		t.Errorf("replay after 8 with spots arriving = %v, want %v, the replayed one only once", got, want)
+	}
+	if got := stream(""); len(got) != 0 {
+		t.Errorf("stream without Last-Event-ID = %v, want no replay", got)
+	}
+
+	spotIndex = NewSpotIndex()
+	for sequence := uint64(1); sequence <= StreamReplayLimit+500; sequence++ {
+		spotIndex.Insert(&Payload{SequenceNumber: sequence, Time: 10000 + sequence})
+	}
+	if got := stream("1"); len(got) != StreamReplayLimit || got[0] != "501" || got[len(got)-1] != "1500" {
+		t.Errorf("replay of %d missed = %d spots, want the newest %d", StreamReplayLimit+499, len(got), StreamReplayLimit)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantLimit  int
+		wantCursor PageCursor
+		wantErr    bool
+	}{
+		{"defaults", "", DefaultPageLimit, PageCursor{}, false},
+		{"capped", "limit=100000", MaxPageLimit, PageCursor{}, false},
+		{"cursor", "limit=10&before=1700000000-42", 10, PageCursor{Time: 1700000000, Sequence: 42}, false},
+		{"time", "before=2023-11-14T22:13:20Z", DefaultPageLimit, PageCursor{Time: 1700000000}, false},
+		{"bad limit", "limit=0", 0, PageCursor{}, true},
+		{"bad before", "before=yesterday", 0, PageCursor{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			limit, cursor, err := parsePage(query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if limit != tt.wantLimit || cursor != tt.wantCursor {
+				t.Errorf("parsePage() = %v, %v, want %v, %v", limit, cursor, tt.wantLimit, tt.wantCursor)
+			}
+		})
+	}
+}
+
+func TestFindPage(t *testing.T) {
+	spotIndex = NewSpotIndex()
+	defer func() { spotIndex = NewSpotIndex() }()
+	for sequence, time := range []uint64{100, 200, 200, 200, 300} {
+		spotIndex.Insert(&Payload{SequenceNumber: uint64(sequence + 1), Time: time})
+	}
+
+	// Paging two at a time goes through spots of the same second without
+	// skipping or repeating any
+	var pages [][]uint64
+	var cursor PageCursor
+	for {
+		page := findPage(Filter{}, cursor, 2)
+		pages = append(pages, sequences(page))
+		if len(page) < 2 {
+			break
+		}
+		last := page[len(page)-1]
+		cursor = PageCursor{Time: last.Time, Sequence: last.SequenceNumber}
+	}
+	if want := [][]uint64{{5, 4}, {3, 2}, {1}}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("findPage() pages = %v, want %v", pages, want)
+	}
+
+	if got := sequences(findPage(Filter{}, PageCursor{Time: 300}, 10)); !slices.Equal(got, []uint64{4, 3, 2, 1}) {
+		t.Errorf("findPage() before a time = %v, want 4, 3, 2, 1", got)
+	}
+}
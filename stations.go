@@ -0,0 +1,139 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ActiveStations counts the distinct callsigns seen in the monitored countries
+// over sliding windows, which tells how many stations are on the air without
+// giving every callsign a label of its own
+type ActiveStations struct {
+	windows []time.Duration
+	seen    map[activeStation]time.Time
+	lock    sync.Mutex
+	desc    *prometheus.Desc
+}
+
+type activeStation struct {
+	Country   int
+	Band      string
+	Mode      string
+	Direction string
+	Callsign  string
+}
+
+func NewActiveStations(windows []time.Duration) *ActiveStations {
+	return &ActiveStations{
+		windows: windows,
+		seen:    make(map[activeStation]time.Time),
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, "", "active_stations"),
+			"Distinct stations in the monitored country seen during the window",
+			[]string{"country", "band", "mode", "direction", "window"}, nil,
+		),
+	}
+}
+
+// Observe the monitored country's end(s) of a spot
+func (stations *ActiveStations) Observe(spot *Payload, classifications []Classification) {
+	seen := time.Unix(int64(spot.Time), 0)
+
+	stations.lock.Lock()
+	defer stations.lock.Unlock()
+
+	for _, classification := range classifications {
+		station := activeStation{
+			Country:   classification.Country,
+			Band:      spot.Band,
+			Mode:      spot.Mode,
+			Direction: classification.Direction,
+		}
+		for _, callsign := range monitoredCallsigns(spot, classification.Direction) {
+			station.Callsign = callsign
+			if seen.After(stations.seen[station]) {
+				stations.seen[station] = seen
+			}
+		}
+	}
+}
+
+// Prune forgets the stations not seen within any window, so that those which
+// were on the air once don't pile up in between scrapes
+func (stations *ActiveStations) Prune(now time.Time) {
+	longest := slices.Max(stations.windows)
+
+	stations.lock.Lock()
+	defer stations.lock.Unlock()
+
+	for station, seen := range stations.seen {
+		if now.Sub(seen) > longest {
+			delete(stations.seen, station)
+		}
+	}
+}
+
+// The callsigns at the monitored country's end(s) of a spot, in upper case
+func monitoredCallsigns(spot *Payload, direction string) []string {
+	switch direction {
+	case DirectionSent:
+		return []string{strings.ToUpper(spot.SenderCallsign)}
+	case DirectionReceived:
+		return []string{strings.ToUpper(spot.ReceiverCallsign)}
+	case DirectionLocal:
+		return []string{strings.ToUpper(spot.SenderCallsign), strings.ToUpper(spot.ReceiverCallsign)}
+	}
+	return nil
+}
+
+func (stations *ActiveStations) Describe(descs chan<- *prometheus.Desc) {
+	descs <- stations.desc
+}
+
+func (stations *ActiveStations) Collect(metrics chan<- prometheus.Metric) {
+	type key struct {
+		Country   int
+		Band      string
+		Mode      string
+		Direction string
+		Window    int
+	}
+	counts := make(map[key]int)
+	now := time.Now()
+
+	stations.lock.Lock()
+	for station, seen := range stations.seen {
+		age := now.Sub(seen)
+		if age > slices.Max(stations.windows) {
+			delete(stations.seen, station)
+			continue
+		}
+		for i, window := range stations.windows {
+			if age <= window {
+				counts[key{station.Country, station.Band, station.Mode, station.Direction, i}] += 1
+			}
+		}
+	}
+	stations.lock.Unlock()
+
+	for k, count := range counts {
+		metrics <- prometheus.MustNewConstMetric(stations.desc, prometheus.GaugeValue, float64(count),
+			strconv.Itoa(k.Country), k.Band, k.Mode, k.Direction, formatWindow(stations.windows[k.Window]))
+	}
+}
+
+// Label-friendly durations, "15m" instead of "15m0s"
+func formatWindow(window time.Duration) string {
+	formatted := window.String()
+	if strings.HasSuffix(formatted, "m0s") {
+		formatted = strings.TrimSuffix(formatted, "0s")
+	}
+	if strings.HasSuffix(formatted, "h0m") {
+		formatted = strings.TrimSuffix(formatted, "0m")
+	}
+	return formatted
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActiveStations(t *testing.T) {
+	ago := func(age time.Duration) uint64 {
+		return uint64(time.Now().Add(-age).Unix())
+	}
+	header := `
+# HELP pskreporter_active_stations Distinct stations in the monitored country seen during the window
+# TYPE pskreporter_active_stations gauge
+`
+	tests := []struct {
+		name  string
+		spots []Payload
+		want  string
+	}{
+		{"nothing seen", nil, ""},
+		{"sent and received", []Payload{
+			{Time: ago(time.Minute), Band: "2m", Mode: "FT8", SenderCallsign: "oh1abc", SenderCountry: 224, ReceiverCallsign: "DL1ABC", ReceiverCountry: 230},
+			{Time: ago(time.Minute), Band: "2m", Mode: "FT8", SenderCallsign: "DL1ABC", SenderCountry: 230, ReceiverCallsign: "OH2ABC", ReceiverCountry: 224},
+		}, header + `
+pskreporter_active_stations{band="2m",country="224",direction="received",mode="FT8",window="15m"} 1
+pskreporter_active_stations{band="2m",country="224",direction="received",mode="FT8",window="1h30m"} 1
+pskreporter_active_stations{band="2m",country="224",direction="sent",mode="FT8",window="15m"} 1
+pskreporter_active_stations{band="2m",country="224",direction="sent",mode="FT8",window="1h30m"} 1
+`},
+		{"same station twice", []Payload{
+			{Time: ago(time.Minute), Band: "2m", Mode: "FT8", SenderCallsign: "OH1ABC", SenderCountry: 224, ReceiverCallsign: "DL1ABC", ReceiverCountry: 230},
+			{Time: ago(2 * time.Minute), Band: "2m", Mode: "FT8", SenderCallsign: "oh1abc", SenderCountry: 224, ReceiverCallsign: "DL2ABC", ReceiverCountry: 230},
+		}, header + `
+pskreporter_active_stations{band="2m",country="224",direction="sent",mode="FT8",window="15m"} 1
+pskreporter_active_stations{band="2m",country="224",direction="sent",mode="FT8",window="1h30m"} 1
+`},
+		{"local, both ends", []Payload{
+			{Time: ago(time.Minute), Band: "70cm", Mode: "FT4", SenderCallsign: "OH1ABC", SenderCountry: 224, ReceiverCallsign: "OH2ABC", ReceiverCountry: 224},
+		}, header + `
+pskreporter_active_stations{band="70cm",country="224",direction="local",mode="FT4",window="15m"} 2
+pskreporter_active_stations{band="70cm",country="224",direction="local",mode="FT4",window="1h30m"} 2
+`},
+		{"only in the longer window", []Payload{
+			{Time: ago(time.Hour), Band: "2m", Mode: "FT8", SenderCallsign: "OH1ABC", SenderCountry: 224, ReceiverCallsign: "DL1ABC", ReceiverCountry: 230},
+		}, header + `
+pskreporter_active_stations{band="2m",country="224",direction="sent",mode="FT8",window="1h30m"} 1
+`},
+		{"outside every window", []Payload{
+			{Time: ago(2 * time.Hour), Band: "2m", Mode: "FT8", SenderCallsign: "OH1ABC", SenderCountry: 224, ReceiverCallsign: "DL1ABC", ReceiverCountry: 230},
+		}, ""},
+	}
+	config := Config{Countries: []int{224}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stations := NewActiveStations([]time.Duration{15 * time.Minute, 90 * time.Minute})
+			for _, spot := range tt.spots {
+				stations.Observe(&spot, Classify(config, &spot))
+			}
+			if err := testutil.CollectAndCompare(stations, strings.NewReader(tt.want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestActiveStationsPrune(t *testing.T) {
+	stations := NewActiveStations([]time.Duration{15 * time.Minute, time.Hour})
+	now := time.Now()
+	for i, age := range []time.Duration{time.Minute, 30 * time.Minute, 2 * time.Hour} {
+		spot := Payload{Time: uint64(now.Add(-age).Unix()), Band: "2m", Mode: "FT8", SenderCallsign: string(rune('A' + i)), SenderCountry: 224}
+		stations.Observe(&spot, []Classification{{224, DirectionSent}})
+	}
+
+	// Forgotten without a scrape, once past the longest window
+	stations.Prune(now)
+	if len(stations.seen) != 2 {
+		t.Errorf("%d stations after Prune(), want the 2 within an hour", len(stations.seen))
+	}
+	stations.Prune(now.Add(time.Hour))
+	if len(stations.seen) != 0 {
+		t.Errorf("%d stations after Prune() an hour later, want none", len(stations.seen))
+	}
+}
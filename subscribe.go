@@ -1,19 +1,14 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/logocomune/maidenhead"
-	"github.com/paulmach/orb"
-	"github.com/paulmach/orb/geo"
 	"github.com/rs/zerolog/log"
-	"math/rand"
 	"os"
-	"os/signal"
-	"strconv"
-	"sync"
-	"syscall"
+	"slices"
 	"time"
 )
 
@@ -34,127 +29,170 @@ type Payload struct {
 	SenderCountry    int     `json:"sa"`
 	ReceiverCountry  int     `json:"ra"`
 	Band             string  `json:"b"`
-}
 
-var (
-	seenMessages map[mqtt.Message]time.Time
-	seenMutex    sync.Mutex
-)
+	// DXCC entities of both ends, where known
+	SenderDxcc   *DxccEntity `json:"senderDxcc,omitempty"`
+	ReceiverDxcc *DxccEntity `json:"receiverDxcc,omitempty"`
+
+	// Both locators resolved, and Distance is to be trusted
+	located bool
+}
 
 const TimeFormat = "15:04:05"
 
-func Subscribe(config Config, spots chan<- *Payload) {
+// Subscribe feeds messages from MQTT to the ingester until the context is
+// done, resubscribing on every nudge from reloads
+func Subscribe(ctx context.Context, config Config, ingester *Ingester, reloads <-chan struct{}) {
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(config.Broker)
-	opts.SetKeepAlive(10 * time.Second)
+	for _, broker := range config.Brokers {
+		opts.AddBroker(broker)
+	}
+	opts.SetKeepAlive(config.MqttKeepalive)
 	opts.SetPingTimeout(2 * time.Second)
 	opts.SetOrderMatters(false)
 	opts.SetConnectRetry(true)
 	opts.SetAutoReconnect(true)
-
-	topics := make(map[string]byte)
-	for _, topic := range config.Topics {
-		topics[topic] = 0
+	opts.SetCleanSession(config.MqttCleanSession)
+	if config.MqttClientId != "" {
+		opts.SetClientID(config.MqttClientId)
+	}
+	if config.MqttUsername != "" {
+		opts.SetUsername(config.MqttUsername)
+		opts.SetPassword(config.MqttPassword)
+	}
+	if tlsConfig, err := newTlsConfig(config); err != nil {
+		log.Fatal().Err(err).Msg("Could not set up MQTT TLS")
+	} else {
+		opts.SetTLSConfig(tlsConfig)
 	}
 
-	seenMessages = make(map[mqtt.Message]time.Time)
+	onMessage := func(client mqtt.Client, message mqtt.Message) {
+		ingester.Ingest(message.Topic(), message.Payload())
+	}
 
 	opts.OnConnect = func(client mqtt.Client) {
-		log.Info().Str("server", config.Broker).Msg("Subscribing")
-
-		token := client.SubscribeMultiple(topics, func(client mqtt.Client, message mqtt.Message) {
-			// Keep track of duplicates
-			seenMutex.Lock()
-			if _, seen := seenMessages[message]; seen {
-				seenMutex.Unlock()
-				prune()
-				return
-			}
-			seenMessages[message] = time.Now()
-			seenMutex.Unlock()
-
-			var payload Payload
-			if err := json.Unmarshal(message.Payload(), &payload); err != nil {
-				log.Error().Err(err).Msg("Payload unmarshalling failed")
-				return
-			}
-			payload.SequenceHex = fmt.Sprintf("%X", payload.SequenceNumber)
-			payload.FormattedTime = time.Unix(int64(payload.Time), 0).UTC().Format(TimeFormat)
-			payload.Mhz = float64(payload.Frequency) / 1000000
-
-			// Calculate distance between stations, best effort
-			senderLatitude, senderLongitude, _ := maidenhead.GridCenter(payload.SenderLocator)
-			receiverLatitude, receiverLongitude, _ := maidenhead.GridCenter(payload.ReceiverLocator)
-			payload.Distance = int64(geo.DistanceHaversine(orb.Point{senderLongitude, senderLatitude}, orb.Point{receiverLongitude, receiverLatitude}) / 1000)
-
-			spots <- &payload
-
-			if payload.SenderCountry == payload.ReceiverCountry {
-				log.Debug().Str("topic", message.Topic()).Any("payload", payload).Msg("Recording message within same country")
-				local_metric.WithLabelValues(strconv.Itoa(config.Country), payload.Band, payload.Mode).Inc()
-			} else if payload.SenderCountry == config.Country {
-				log.Debug().Str("topic", message.Topic()).Any("payload", payload).Msg("Recording message sent from target country")
-				sent_metric.WithLabelValues(strconv.Itoa(config.Country), payload.Band, payload.Mode).Inc()
-			} else if payload.ReceiverCountry == config.Country {
-				log.Debug().Str("topic", message.Topic()).Any("payload", payload).Msg("Recording message received in target country")
-				received_metric.WithLabelValues(strconv.Itoa(config.Country), payload.Band, payload.Mode).Inc()
-			} else {
-				// Not sure how we got here
-				log.Debug().Str("topic", message.Topic()).Any("payload", payload).Msg("No country matches, skipping")
-			}
-		})
-
-		go func() {
-			<-token.Done()
-			//_ = token.Wait() // Can also use '<-t.Done()' in releases > 1.2.0
-			if token.Error() != nil {
-				log.Err(token.Error()).Msg("Error subscribing")
-			} else {
-				log.Info().Any("topics", topics).Msg("Subscribed")
-			}
-		}()
+		setConnected(true)
+		log.Info().Strs("servers", config.Brokers).Msg("Subscribing")
+		subscribe(client, CurrentConfig().Topics, config.MqttQos, onMessage)
 	}
 
 	opts.OnConnectionLost = func(cl mqtt.Client, err error) {
+		setConnected(false)
 		log.Err(err).Msg("Connection lost")
 	}
 
 	opts.OnReconnecting = func(mqtt.Client, *mqtt.ClientOptions) {
+		reconnects_metric.Inc()
 		log.Info().Msg("Reconnecting")
 	}
 
 	client := mqtt.NewClient(opts)
 
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Err(token.Error()).Msg("")
-		time.Sleep(time.Duration(time.Second))
+	// Reloads wait until connected, shutting down doesn't
+	token := client.Connect()
+	select {
+	case <-token.Done():
+		if token.Error() != nil {
+			log.Err(token.Error()).Msg("")
+			time.Sleep(time.Duration(time.Second))
+		}
+		log.Info().Strs("servers", config.Brokers).Msg("Connected")
+	case <-ctx.Done():
+	}
+
+	topics := CurrentConfig().Topics
+	for {
+		select {
+		case <-ctx.Done():
+			client.Disconnect(1000)
+			setConnected(false)
+			log.Info().Msg("Disconnected")
+			return
+		case <-reloads:
+			next := CurrentConfig()
+			resubscribe(client, topics, next.Topics, next.MqttQos, onMessage)
+			topics = next.Topics
+		}
+	}
+}
+
+func subscribe(client mqtt.Client, topics []string, qos byte, callback mqtt.MessageHandler) {
+	filters := make(map[string]byte)
+	for _, topic := range topics {
+		filters[topic] = qos
+	}
+
+	token := client.SubscribeMultiple(filters, callback)
+
+	go func() {
+		<-token.Done()
+		//_ = token.Wait() // Can also use '<-t.Done()' in releases > 1.2.0
+		if token.Error() != nil {
+			log.Err(token.Error()).Msg("Error subscribing")
+		} else {
+			mqttSubscribed.Store(true)
+			log.Info().Any("topics", filters).Msg("Subscribed")
+		}
+	}()
+}
+
+// Change subscriptions from previous topics to next ones, leaving alone those in both
+func resubscribe(client mqtt.Client, previous []string, next []string, qos byte, callback mqtt.MessageHandler) {
+	var removed, added []string
+	for _, topic := range previous {
+		if !slices.Contains(next, topic) {
+			removed = append(removed, topic)
+		}
+	}
+	for _, topic := range next {
+		if !slices.Contains(previous, topic) {
+			added = append(added, topic)
+		}
+	}
+
+	if len(removed) > 0 {
+		token := client.Unsubscribe(removed...)
+		go func() {
+			<-token.Done()
+			if token.Error() != nil {
+				log.Err(token.Error()).Msg("Error unsubscribing")
+			} else {
+				forgetTopics(removed)
+				log.Info().Strs("topics", removed).Msg("Unsubscribed")
+			}
+		}()
 	}
-	log.Info().Str("server", config.Broker).Msg("Connected")
 
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
-	signal.Notify(sig, syscall.SIGTERM)
-	log.Info().Any("signal", <-sig).Msg("Signal caught, exiting")
-	client.Disconnect(1000)
+	if len(added) > 0 {
+		subscribe(client, added, qos, callback)
+	}
 }
 
-// Clean up already-seen messages, occasionally
-func prune() {
-	if rand.Float32() < 0.9 {
-		return
-	}
-	log.Debug().Any("length", len(seenMessages)).Msg("Start pruning duplicate lookup table")
-
-	count := 0
-	now := time.Now()
-	seenMutex.Lock()
-	defer seenMutex.Unlock()
-	for key, seen := range seenMessages {
-		if now.Sub(seen) > time.Duration(time.Minute) {
-			delete(seenMessages, key)
-			count += 1
+// TLS settings for ssl:// and wss:// brokers, with the system's CAs unless
+// told otherwise
+func newTlsConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.MqttTlsInsecure,
+	}
+
+	if config.MqttTlsCa != "" {
+		ca, err := os.ReadFile(config.MqttTlsCa)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in %s", config.MqttTlsCa)
+		}
+	}
+
+	if config.MqttTlsCert != "" {
+		certificate, err := tls.LoadX509KeyPair(config.MqttTlsCert, config.MqttTlsKey)
+		if err != nil {
+			return nil, err
 		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
 	}
 
-	log.Debug().Any("length", len(seenMessages)).Int("pruned", count).Msg("Done pruning duplicate lookup table")
+	return tlsConfig, nil
 }
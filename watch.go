@@ -0,0 +1,499 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	MaxWatches          = 256
+	MaxWatchNameLength  = 64
+	MaxWatchRequestSize = 1 << 16
+	StreamEventWatch    = "watch"
+)
+
+// Watch is a named filter, given with the same parameters as the spotlog
+// takes, whose matching spots are to be told about
+type Watch struct {
+	Name       string            `json:"name" yaml:"name"`
+	Parameters map[string]string `json:"parameters" yaml:"parameters"`
+
+	filter Filter
+}
+
+// WatchEvent tells about a spot that matched a watch, and how many more did
+// on the same band since the previous event, without being told about
+type WatchEvent struct {
+	Watch   string   `json:"watch"`
+	Spot    *Payload `json:"spot"`
+	Repeats int      `json:"repeats"`
+}
+
+type watchKey struct {
+	Watch string
+	Band  string
+}
+
+type watchNotice struct {
+	time    uint64
+	repeats int
+}
+
+// WatchList holds the watches, as read from WATCH_FILE and changed through
+// the admin API, writing them back to the file on every change. Repeats are
+// held back per watch and band, going by the spots' time like the opening
+// detector does.
+type WatchList struct {
+	path     string
+	lock     sync.Mutex
+	watches  []*Watch
+	notified map[watchKey]*watchNotice
+}
+
+var (
+	watchList *WatchList
+
+	// Changes that could not be written to WATCH_FILE are not made
+	errSavingWatches = errors.New("could not save watch list")
+)
+
+// LoadWatchList reads the watches from the file, if there's one; a file that
+// doesn't exist yet starts out an empty list, and gets created on the first
+// change
+func LoadWatchList(config Config) (*WatchList, error) {
+	list := &WatchList{
+		path:     config.WatchFile,
+		notified: make(map[watchKey]*watchNotice),
+	}
+	if err := list.Reload(config); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Reload reads the file again, or when there's none, builds the filters
+// again for the configuration given
+func (list *WatchList) Reload(config Config) error {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+
+	var watches []Watch
+	if list.path == "" {
+		for _, watch := range list.watches {
+			watches = append(watches, *watch)
+		}
+	} else {
+		data, err := os.ReadFile(list.path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("WATCH_FILE: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &watches); err != nil {
+			return fmt.Errorf("WATCH_FILE: %w", err)
+		}
+	}
+	if len(watches) > MaxWatches {
+		return fmt.Errorf("WATCH_FILE: %d watches, at most %d allowed", len(watches), MaxWatches)
+	}
+
+	var next []*Watch
+	for _, watch := range watches {
+		built, err := newWatch(config, watch.Name, watch.Parameters)
+		if err != nil {
+			return fmt.Errorf("WATCH_FILE: %w", err)
+		}
+		if slices.ContainsFunc(next, func(known *Watch) bool { return known.Name == built.Name }) {
+			return fmt.Errorf("WATCH_FILE: watch %q given more than once", built.Name)
+		}
+		next = append(next, built)
+	}
+
+	list.watches = next
+	list.forget()
+	log.Info().Int("watches", len(next)).Msg("Watch list loaded")
+	return nil
+}
+
+// A watch's name has to fit in a path, and its parameters have to narrow the
+// spots down to something worth telling about
+func newWatch(config Config, name string, parameters map[string]string) (*Watch, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > MaxWatchNameLength || strings.ContainsFunc(name, unicode.IsControl) || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("watch name %q is not 1 to %d printable characters without slashes", name, MaxWatchNameLength)
+	}
+
+	query := make(url.Values)
+	for key, value := range parameters {
+		if !slices.Contains(FilterParameters, key) {
+			return nil, fmt.Errorf("watch %q: unknown parameter %q", name, key)
+		}
+		query.Set(key, value)
+	}
+	filter := newFilter(config, query)
+	if err := filter.Err(); err != nil {
+		return nil, fmt.Errorf("watch %q: %w", name, err)
+	}
+
+	// The filter quietly leaves out what it can't go by, which for a watch
+	// would mean telling about far more than was asked for
+	for _, list := range []struct {
+		parameter string
+		kept      []string
+	}{
+		{"bands", filter.Bands},
+		{"modes", filter.Modes},
+		{"continent", filter.Continents},
+		{"entity", filter.Entities},
+		{"direction", filter.Directions},
+	} {
+		for _, value := range strings.Split(parameters[list.parameter], ",") {
+			value = strings.TrimSpace(value)
+			if value != "" && !slices.ContainsFunc(list.kept, func(kept string) bool { return strings.EqualFold(kept, value) }) {
+				return nil, fmt.Errorf("watch %q: %s: %q is not known here", name, list.parameter, value)
+			}
+		}
+	}
+	for _, bound := range []struct {
+		parameter string
+		parsed    bool
+	}{
+		{"min_distance", filter.MinDistance != nil},
+		{"max_distance", filter.MaxDistance != nil},
+		{"min_report", filter.MinReport != nil},
+		{"max_report", filter.MaxReport != nil},
+		{"min_freq", filter.MinFrequency != nil},
+		{"max_freq", filter.MaxFrequency != nil},
+	} {
+		if parameters[bound.parameter] != "" && !bound.parsed {
+			return nil, fmt.Errorf("watch %q: %s: %q is not a number", name, bound.parameter, parameters[bound.parameter])
+		}
+	}
+	for _, parameter := range []string{"since", "until"} {
+		if _, err := parseTimestamp(parameters[parameter]); err != nil {
+			return nil, fmt.Errorf("watch %q: %s: %w", name, parameter, err)
+		}
+	}
+	if !filter.Enabled {
+		return nil, fmt.Errorf("watch %q: parameters would let every spot through", name)
+	}
+
+	return &Watch{Name: name, Parameters: parameters, filter: filter}, nil
+}
+
+// Watches are copies of the current ones, in the order they were added
+func (list *WatchList) Watches() []Watch {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+
+	watches := make([]Watch, 0, len(list.watches))
+	for _, watch := range list.watches {
+		watches = append(watches, *watch)
+	}
+	return watches
+}
+
+func (list *WatchList) Len() int {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+
+	return len(list.watches)
+}
+
+// Put adds the watch, or replaces the one with the same name, telling which
+func (list *WatchList) Put(config Config, name string, parameters map[string]string) (watch Watch, created bool, err error) {
+	built, err := newWatch(config, name, parameters)
+	if err != nil {
+		return Watch{}, false, err
+	}
+
+	list.lock.Lock()
+	defer list.lock.Unlock()
+
+	next := slices.Clone(list.watches)
+	if i := slices.IndexFunc(next, func(known *Watch) bool { return known.Name == built.Name }); i >= 0 {
+		next[i] = built
+	} else {
+		if len(next) >= MaxWatches {
+			return Watch{}, false, fmt.Errorf("at most %d watches allowed", MaxWatches)
+		}
+		next = append(next, built)
+		created = true
+	}
+	if err := list.save(next); err != nil {
+		return Watch{}, false, err
+	}
+
+	// A changed watch starts over
+	list.watches = next
+	for key := range list.notified {
+		if key.Watch == built.Name {
+			delete(list.notified, key)
+		}
+	}
+	return *built, created, nil
+}
+
+// Delete removes the watch, telling whether there was one by the name
+func (list *WatchList) Delete(name string) (bool, error) {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+
+	i := slices.IndexFunc(list.watches, func(known *Watch) bool { return known.Name == name })
+	if i < 0 {
+		return false, nil
+	}
+	next := slices.Delete(slices.Clone(list.watches), i, i+1)
+	if err := list.save(next); err != nil {
+		return false, err
+	}
+
+	list.watches = next
+	list.forget()
+	return true, nil
+}
+
+// Match the spot against every watch, returning an event for each that
+// hasn't told about the spot's band within WATCH_REPEAT. Time goes by the
+// spots, held to the clock like with openings.
+func (list *WatchList) Match(config Config, spot *Payload) []WatchEvent {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+
+	now := spotTime(config, spot)
+	repeat := uint64(config.WatchRepeat.Seconds())
+	var events []WatchEvent
+	for _, watch := range list.watches {
+		if !watch.filter.filter(spot) {
+			continue
+		}
+
+		key := watchKey{Watch: watch.Name, Band: spot.Band}
+		notice, ok := list.notified[key]
+		if ok && now < notice.time+repeat {
+			notice.repeats += 1
+			continue
+		}
+		event := WatchEvent{Watch: watch.Name, Spot: spot}
+		if ok {
+			event.Repeats = notice.repeats
+		}
+		list.notified[key] = &watchNotice{time: now}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// Let go of the repeats of watches that are no more
+func (list *WatchList) forget() {
+	for key := range list.notified {
+		if !slices.ContainsFunc(list.watches, func(watch *Watch) bool { return watch.Name == key.Watch }) {
+			delete(list.notified, key)
+		}
+	}
+}
+
+// Write the watches to the file, if there's one, through a temporary file so
+// that a crash doesn't leave half of them behind
+func (list *WatchList) save(watches []*Watch) error {
+	if list.path == "" {
+		return nil
+	}
+	if err := writeWatchFile(list.path, watches); err != nil {
+		return fmt.Errorf("%w: %w", errSavingWatches, err)
+	}
+	return nil
+}
+
+func writeWatchFile(path string, watches []*Watch) error {
+	data, err := yaml.Marshal(watches)
+	if err != nil {
+		return err
+	}
+	temporary, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporary.Name())
+	if _, err := temporary.Write(data); err != nil {
+		temporary.Close()
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temporary.Name(), path)
+}
+
+// Tell everyone who wants to know about the watched spot
+func announceWatch(config Config, event WatchEvent) {
+	log.Info().Str("watch", event.Watch).Str("band", event.Spot.Band).Str("sender", event.Spot.SenderCallsign).
+		Str("receiver", event.Spot.ReceiverCallsign).Int("repeats", event.Repeats).Msg("Watched spot")
+
+	StreamLock.Lock()
+	for _, streamer := range Streamers {
+		select {
+		case streamer.Watches <- event:
+		default:
+		}
+	}
+	StreamLock.Unlock()
+
+	postWebhooks(config.WatchWebhooks, "watch", event)
+}
+
+// The admin API takes the token as a bearer token, and is not there at all
+// without one configured
+func authorizeWatchAdmin(config Config, writer http.ResponseWriter, request *http.Request) bool {
+	token := config.WatchAdminToken
+	if token == "" || watchList == nil {
+		http.Error(writer, "watch list admin API is disabled", http.StatusNotFound)
+		return false
+	}
+	given, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		writer.Header().Set("WWW-Authenticate", `Bearer realm="watches"`)
+		http.Error(writer, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func writeWatchJson(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		log.Debug().Err(err).Msg("Could not write watches")
+	}
+}
+
+func watchesHandler(getConfig func() Config) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !authorizeWatchAdmin(getConfig(), writer, request) {
+			return
+		}
+		writeWatchJson(writer, http.StatusOK, watchList.Watches())
+	}
+}
+
+// The body is the parameters as a JSON object, as with the WebSocket
+func putWatchHandler(getConfig func() Config) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !authorizeWatchAdmin(getConfig(), writer, request) {
+			return
+		}
+
+		var parameters map[string]string
+		body := http.MaxBytesReader(writer, request.Body, MaxWatchRequestSize)
+		if err := json.NewDecoder(body).Decode(&parameters); err != nil {
+			http.Error(writer, "could not parse parameters: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		watch, created, err := watchList.Put(getConfig(), request.PathValue("name"), parameters)
+		if err != nil {
+			if errors.Is(err, errSavingWatches) {
+				log.Error().Err(err).Msg("Could not save watch list")
+				http.Error(writer, errSavingWatches.Error(), http.StatusInternalServerError)
+			} else {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+
+		log.Info().Str("watch", watch.Name).Bool("created", created).Msg("Watch put")
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		writeWatchJson(writer, status, watch)
+	}
+}
+
+func deleteWatchHandler(getConfig func() Config) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !authorizeWatchAdmin(getConfig(), writer, request) {
+			return
+		}
+
+		name := request.PathValue("name")
+		deleted, err := watchList.Delete(name)
+		if err != nil {
+			log.Error().Err(err).Msg("Could not save watch list")
+			http.Error(writer, errSavingWatches.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(writer, "no such watch", http.StatusNotFound)
+			return
+		}
+
+		log.Info().Str("watch", name).Msg("Watch deleted")
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Watched spots as server-sent events, for the page to notify about
+func watchStreamHandler(writer http.ResponseWriter, request *http.Request) {
+	log.Debug().Msg("Streaming watched spots")
+	id, streamer, ok := registerStreamer(&Streamer{Watches: make(chan WatchEvent, 16)})
+	if !ok {
+		http.Error(writer, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer removeStreamer(id)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	io.WriteString(writer, ": keepalive\n\n")
+	if flusher, ok := writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	keepalive := time.NewTicker(25 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			log.Debug().Uint64("id", id).Msg("Watch streamer is gone")
+			return
+		case <-spotlogStopping:
+			io.WriteString(writer, fmt.Sprintf("retry: %d\nevent: %s\ndata: Server shutting down\n\n", StreamShutdownRetry.Milliseconds(), StreamEventShutdown))
+			if flusher, ok := writer.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			return
+		case <-keepalive.C:
+			StreamLock.Lock()
+			streamer.Keepalive = time.Now()
+			StreamLock.Unlock()
+			io.WriteString(writer, ": keepalive\n\n")
+		case event := <-streamer.Watches:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Error().Err(err).Msg("Could not marshal watched spot")
+				continue
+			}
+			io.WriteString(writer, fmt.Sprintf("event: %s\ndata: %s\n\n", StreamEventWatch, data))
+		}
+		if flusher, ok := writer.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchList(t *testing.T) {
+	config := Config{Bands: []string{"2m", "23cm"}, Countries: []int{224}, WatchFile: filepath.Join(t.TempDir(), "watches.yaml"), WatchRepeat: 30 * time.Minute}
+	list, err := LoadWatchList(config)
+	if err != nil || list.Len() != 0 {
+		t.Fatalf("LoadWatchList() = %v, %v, want an empty list from a missing file", list, err)
+	}
+
+	if _, created, err := list.Put(config, "OH6XYZ", map[string]string{"callsign": "OH6XYZ"}); err != nil || !created {
+		t.Fatalf("Put() = %v, %v, want created", created, err)
+	}
+	if _, created, err := list.Put(config, " KP11 ", map[string]string{"locator": "KP11", "bands": "23cm"}); err != nil || !created {
+		t.Fatalf("Put() = %v, %v, want created", created, err)
+	}
+	for _, bad := range []struct {
+		name       string
+		parameters map[string]string
+	}{
+		{"everything", map[string]string{}},
+		{"typo", map[string]string{"callsgn": "OH6XYZ"}},
+		{"unknown parameter", map[string]string{"callsign": "OH6XYZ", "colour": "red"}},
+		{"band typo", map[string]string{"callsign": "OH6XYZ", "bands": "23cn"}},
+		{"band not here", map[string]string{"callsign": "OH6XYZ", "bands": "2m,6m"}},
+		{"mode too long", map[string]string{"callsign": "OH6XYZ", "modes": "FT8,SUPERLONGMODE"}},
+		{"bad continent", map[string]string{"callsign": "OH6XYZ", "continent": "XX"}},
+		{"bad bound", map[string]string{"callsign": "OH6XYZ", "min_distance": "far"}},
+		{"bad query", map[string]string{"q": "distance >"}},
+		{"a/b", map[string]string{"callsign": "OH6XYZ"}},
+		{"", map[string]string{"callsign": "OH6XYZ"}},
+	} {
+		if _, _, err := list.Put(config, bad.name, bad.parameters); err == nil {
+			t.Errorf("Put(%q, %v) error = nil, want one", bad.name, bad.parameters)
+		}
+	}
+	if _, _, err := list.Put(config, "bad since", map[string]string{"callsign": "OH6XYZ", "since": "yesterday"}); err == nil || !strings.Contains(err.Error(), "timestamp") {
+		t.Errorf("Put() error = %v, want one about the timestamp", err)
+	}
+
+	match := func(spot *Payload) string {
+		var watches []string
+		for _, event := range list.Match(config, spot) {
+			watches = append(watches, event.Watch)
+			if event.Repeats > 0 {
+				watches = append(watches, strings.Repeat("+", event.Repeats))
+			}
+		}
+		return strings.Join(watches, " ")
+	}
+	spot := func(time uint64, band string, locator string) *Payload {
+		return &Payload{Time: time, Band: band, SenderCallsign: "OH6XYZ", SenderLocator: locator, ReceiverCallsign: "SM5ABC", ReceiverLocator: "JO89"}
+	}
+	for _, step := range []struct {
+		spot *Payload
+		want string
+	}{
+		{spot(1000, "23cm", "KP11ab"), "OH6XYZ KP11"},
+		{spot(1060, "23cm", "KP11ab"), ""},
+		{spot(1120, "2m", "KP11ab"), "OH6XYZ"},
+		{spot(1180, "23cm", "KP20le"), ""},
+		{spot(2800, "23cm", "KP11ab"), "OH6XYZ ++ KP11 +"},
+	} {
+		if got := match(step.spot); got != step.want {
+			t.Errorf("Match(%d, %s) = %q, want %q", step.spot.Time, step.spot.Band, got, step.want)
+		}
+	}
+
+	// A receiver far ahead of the clock doesn't mute the watch until then
+	clock := time.Unix(4000, 0)
+	spotClock = func() time.Time { return clock }
+	defer func() { spotClock = time.Now }()
+	if got := match(spot(1000000000, "2m", "KP11ab")); got != "OH6XYZ" {
+		t.Errorf("Match() of a spot from the future = %q, want OH6XYZ", got)
+	}
+	clock = time.Unix(6000, 0)
+	if got := match(spot(6000, "2m", "KP11ab")); got != "OH6XYZ" {
+		t.Errorf("Match() after WATCH_REPEAT from the clock = %q, want OH6XYZ", got)
+	}
+
+	// Back from the file as it was left
+	if deleted, err := list.Delete("OH6XYZ"); err != nil || !deleted {
+		t.Fatalf("Delete() = %v, %v, want deleted", deleted, err)
+	}
+	if deleted, _ := list.Delete("OH6XYZ"); deleted {
+		t.Errorf("Delete() = true, want nothing left to delete")
+	}
+	reloaded, err := LoadWatchList(config)
+	if err != nil {
+		t.Fatalf("LoadWatchList() error = %v", err)
+	}
+	if watches := reloaded.Watches(); len(watches) != 1 || watches[0].Name != "KP11" || watches[0].Parameters["bands"] != "23cm" {
+		t.Errorf("Watches() = %+v, want KP11 on 23cm", watches)
+	}
+
+	os.WriteFile(config.WatchFile, []byte("- name: twice\n  parameters: {callsign: OH2}\n- name: twice\n  parameters: {callsign: OH6}\n"), 0644)
+	if err := reloaded.Reload(config); err == nil || reloaded.Len() != 1 {
+		t.Errorf("Reload() = %v, leaving %d, want an error and the previous watch", err, reloaded.Len())
+	}
+}
+
+func TestWatchHandlers(t *testing.T) {
+	config := Config{Bands: []string{"2m"}, WatchAdminToken: "sekrit"}
+	getConfig := func() Config { return config }
+	var err error
+	if watchList, err = LoadWatchList(Config{}); err != nil {
+		t.Fatalf("LoadWatchList() error = %v", err)
+	}
+	defer func() { watchList = nil }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/watches", watchesHandler(getConfig))
+	mux.HandleFunc("PUT /api/watches/{name}", putWatchHandler(getConfig))
+	mux.HandleFunc("DELETE /api/watches/{name}", deleteWatchHandler(getConfig))
+
+	request := func(method string, path string, token string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if got := request("GET", "/api/watches", "", "").Code; got != http.StatusUnauthorized {
+		t.Errorf("GET without token = %d, want %d", got, http.StatusUnauthorized)
+	}
+	if got := request("GET", "/api/watches", "wrong", "").Code; got != http.StatusUnauthorized {
+		t.Errorf("GET with wrong token = %d, want %d", got, http.StatusUnauthorized)
+	}
+	if got := request("PUT", "/api/watches/rare", "sekrit", `{"callsign": "OH6XYZ", "bands": "2m"}`).Code; got != http.StatusCreated {
+		t.Errorf("PUT = %d, want %d", got, http.StatusCreated)
+	}
+	if got := request("PUT", "/api/watches/rare", "sekrit", `{"callsign": "OH6XYZ"}`).Code; got != http.StatusOK {
+		t.Errorf("PUT again = %d, want %d", got, http.StatusOK)
+	}
+	if got := request("PUT", "/api/watches/all", "sekrit", `{}`).Code; got != http.StatusBadRequest {
+		t.Errorf("PUT of everything = %d, want %d", got, http.StatusBadRequest)
+	}
+
+	response := request("GET", "/api/watches", "sekrit", "")
+	var watches []Watch
+	if err := json.NewDecoder(response.Body).Decode(&watches); err != nil || len(watches) != 1 || watches[0].Parameters["callsign"] != "OH6XYZ" {
+		t.Errorf("GET = %+v, %v, want the one watch", watches, err)
+	}
+
+	if got := request("DELETE", "/api/watches/rare", "sekrit", "").Code; got != http.StatusNoContent {
+		t.Errorf("DELETE = %d, want %d", got, http.StatusNoContent)
+	}
+	if got := request("DELETE", "/api/watches/rare", "sekrit", "").Code; got != http.StatusNotFound {
+		t.Errorf("DELETE again = %d, want %d", got, http.StatusNotFound)
+	}
+
+	config = Config{}
+	if got := request("GET", "/api/watches", "sekrit", "").Code; got != http.StatusNotFound {
+		t.Errorf("GET without a token configured = %d, want %d", got, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const WebhookTimeout = time.Second * 10
+
+var (
+	webhookClient = &http.Client{Timeout: WebhookTimeout}
+	webhooking    sync.WaitGroup
+)
+
+// Post the event as JSON to each of the webhooks, in the background so that
+// a slow receiver doesn't hold up the spots
+func postWebhooks(webhooks []string, kind string, event any) {
+	if len(webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Err(err).Msg("Could not marshal webhook event")
+		return
+	}
+
+	for _, webhook := range webhooks {
+		webhooking.Add(1)
+		go func() {
+			defer webhooking.Done()
+
+			response, err := webhookClient.Post(webhook, "application/json", bytes.NewReader(body))
+			if err != nil {
+				log.Error().Err(err).Str("kind", kind).Msg("Could not post to webhook")
+				webhooks_metric.WithLabelValues(kind, "failure").Inc()
+				return
+			}
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+
+			if response.StatusCode >= 300 {
+				log.Error().Int("status", response.StatusCode).Str("kind", kind).Msg("Webhook refused event")
+				webhooks_metric.WithLabelValues(kind, "failure").Inc()
+				return
+			}
+			webhooks_metric.WithLabelValues(kind, "success").Inc()
+		}()
+	}
+}
+
+// Give the webhooks still being posted a while to finish
+func waitWebhooks() {
+	posted := make(chan struct{})
+	go func() {
+		webhooking.Wait()
+		close(posted)
+	}()
+	select {
+	case <-posted:
+	case <-time.After(ShutdownTimeout):
+		log.Warn().Msg("Gave up waiting for webhooks")
+	}
+}
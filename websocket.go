@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+const (
+	WebsocketPingInterval = 25 * time.Second
+	WebsocketReadTimeout  = 60 * time.Second
+	WebsocketWriteTimeout = 10 * time.Second
+	WebsocketMessageLimit = 4096
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// WebsocketMessage is what goes out over the socket; spots, the filter in
+// effect after an update, or complaints about an update that made no sense
+type WebsocketMessage struct {
+	Type    string        `json:"type"`
+	Spot    *Payload      `json:"spot,omitempty"`
+	Filter  *Filter       `json:"filter,omitempty"`
+	Opening *OpeningEvent `json:"opening,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// The initial filter comes from the query string, like with the event stream.
+// Clients may replace it at any time by sending the same parameters as a JSON
+// object, e.g. {"bands": "2m,70cm", "modes": "FT8"}.
+func websocketHandler(getConfig func() Config) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		config := getConfig()
+		filter := NewFilter(config, request)
+		if err := filter.Err(); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Added while the server still tracks the connection, before it's
+		// hijacked
+		id, streamer, ok := addStreamer()
+		if !ok {
+			http.Error(writer, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		defer removeStreamer(id)
+
+		conn, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			log.Debug().Err(err).Msg("Could not upgrade to WebSocket")
+			return
+		}
+		defer conn.Close()
+
+		// Only this goroutine reads, and only the loop below writes
+		updates := make(chan Filter)
+		done := make(chan struct{})
+		closing := make(chan struct{})
+		defer close(closing)
+		complaints := make(chan string, 1)
+		go func() {
+			defer close(done)
+			conn.SetReadLimit(WebsocketMessageLimit)
+			conn.SetReadDeadline(time.Now().Add(WebsocketReadTimeout))
+			conn.SetPongHandler(func(string) error {
+				return conn.SetReadDeadline(time.Now().Add(WebsocketReadTimeout))
+			})
+			for {
+				var parameters map[string]string
+				if err := conn.ReadJSON(&parameters); err != nil {
+					var syntaxError *json.SyntaxError
+					var typeError *json.UnmarshalTypeError
+					if !errors.As(err, &syntaxError) && !errors.As(err, &typeError) {
+						log.Debug().Uint64("id", id).Err(err).Msg("WebSocket closed")
+						return
+					}
+					select {
+					case complaints <- "could not parse filter: " + err.Error():
+					default:
+					}
+					continue
+				}
+				query := make(url.Values)
+				for key, value := range parameters {
+					query.Set(key, value)
+				}
+				// A query that doesn't parse leaves the previous filter in effect
+				update := newFilter(config, query)
+				if err := update.Err(); err != nil {
+					select {
+					case complaints <- err.Error():
+					default:
+					}
+					continue
+				}
+				select {
+				case updates <- update:
+				case <-closing:
+					return
+				}
+			}
+		}()
+
+		send := func(message WebsocketMessage) bool {
+			conn.SetWriteDeadline(time.Now().Add(WebsocketWriteTimeout))
+			if err := conn.WriteJSON(message); err != nil {
+				log.Debug().Uint64("id", id).Err(err).Msg("Could not write to WebSocket")
+				return false
+			}
+			return true
+		}
+
+		if !send(WebsocketMessage{Type: "filter", Filter: &filter}) {
+			return
+		}
+
+		ping := time.NewTicker(WebsocketPingInterval)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-request.Context().Done():
+				return
+			case <-spotlogStopping:
+				// Last spots first, then a proper goodbye
+				for pending := len(streamer.Spots); pending > 0; pending-- {
+					spot := <-streamer.Spots
+					if filter.Enabled && !filter.filter(spot) {
+						continue
+					}
+					if !send(WebsocketMessage{Type: "spot", Spot: spot}) {
+						return
+					}
+				}
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "Server shutting down"),
+					time.Now().Add(WebsocketWriteTimeout))
+				log.Debug().Uint64("id", id).Msg("WebSocket told about shutdown")
+				return
+			case filter = <-updates:
+				log.Debug().Uint64("id", id).Any("filter", filter).Msg("WebSocket filter updated")
+				if !send(WebsocketMessage{Type: "filter", Filter: &filter}) {
+					return
+				}
+			case event := <-streamer.Openings:
+				if filter.Bands != nil && !slices.Contains(filter.Bands, event.Band) {
+					continue
+				}
+				if !send(WebsocketMessage{Type: StreamEventOpening, Opening: &event}) {
+					return
+				}
+			case message := <-complaints:
+				if !send(WebsocketMessage{Type: "error", Error: message}) {
+					return
+				}
+			case <-ping.C:
+				StreamLock.Lock()
+				streamer.Keepalive = time.Now()
+				StreamLock.Unlock()
+				conn.SetWriteDeadline(time.Now().Add(WebsocketWriteTimeout))
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+			case spot := <-streamer.Spots:
+				if filter.Enabled && !filter.filter(spot) {
+					continue
+				}
+				if !send(WebsocketMessage{Type: "spot", Spot: spot}) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketHandler(t *testing.T) {
+	Streamers = make(map[uint64]*Streamer)
+	spotlogStopping = make(chan struct{})
+	config := Config{Bands: []string{"2m", "70cm"}}
+
+	server := httptest.NewServer(http.HandlerFunc(websocketHandler(func() Config { return config })))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/?bands=2m", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var message WebsocketMessage
+	if err := conn.ReadJSON(&message); err != nil || message.Type != "filter" || message.Filter.Bands[0] != "2m" {
+		t.Fatalf("initial message = %+v, %v, want 2m filter", message, err)
+	}
+
+	if err := conn.WriteJSON(map[string]string{"bands": "70cm"}); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+	message = WebsocketMessage{}
+	if err := conn.ReadJSON(&message); err != nil || message.Type != "filter" || message.Filter.Bands[0] != "70cm" {
+		t.Fatalf("filter update = %+v, %v, want 70cm filter", message, err)
+	}
+
+	StreamLock.Lock()
+	for _, streamer := range Streamers {
+		streamer.Spots <- &Payload{SequenceNumber: 1, Band: "2m"}
+		streamer.Spots <- &Payload{SequenceNumber: 2, Band: "70cm"}
+	}
+	StreamLock.Unlock()
+
+	message = WebsocketMessage{}
+	if err := conn.ReadJSON(&message); err != nil || message.Type != "spot" || message.Spot.SequenceNumber != 2 {
+		t.Fatalf("spot = %+v, %v, want sequence 2", message, err)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("nonsense")); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	message = WebsocketMessage{}
+	if err := conn.ReadJSON(&message); err != nil || message.Type != "error" {
+		t.Fatalf("reply to nonsense = %+v, %v, want error", message, err)
+	}
+}
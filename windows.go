@@ -0,0 +1,152 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"time"
+)
+
+// SpotWindows tells how many spots there were, how far they reached, and how
+// many stations they came from, over each of SPOTS_WINDOWS. It's all counted
+// from the retained spots whenever scraped, so the gauges are right from the
+// start, also after a restart when the spotlog store is replayed.
+type SpotWindows struct {
+	spots     *prometheus.Desc
+	distances *prometheus.Desc
+	stations  *prometheus.Desc
+}
+
+type windowKey struct {
+	Country   int
+	Band      string
+	Mode      string
+	Direction string
+	Window    int
+}
+
+type windowCounts struct {
+	Spots    int
+	Distance int64
+	Located  bool
+	Stations int
+}
+
+func NewSpotWindows() *SpotWindows {
+	labels := []string{"country", "band", "mode", "direction", "window"}
+	return &SpotWindows{
+		spots: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "window"),
+			"Retained spots from the window, by the monitored country's part in them",
+			labels, nil,
+		),
+		distances: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "window_max_distance_km"),
+			"Longest distance between grid square centers among the window's spots",
+			labels, nil,
+		),
+		stations: prometheus.NewDesc(
+			prometheus.BuildFQName(Namespace, Subsystem, "window_stations"),
+			"Distinct stations in the monitored country among the window's spots",
+			labels, nil,
+		),
+	}
+}
+
+func (windows *SpotWindows) Describe(descs chan<- *prometheus.Desc) {
+	descs <- windows.spots
+	descs <- windows.distances
+	descs <- windows.stations
+}
+
+func (windows *SpotWindows) Collect(metrics chan<- prometheus.Metric) {
+	config := CurrentConfig()
+	if len(config.SpotsWindows) == 0 {
+		return
+	}
+	now := time.Now()
+	since := now.Add(-config.SpotsWindows[len(config.SpotsWindows)-1])
+
+	// Copied out, so that the index isn't held up while counting
+	spots := spotIndex.Find(&Filter{Since: uint64(since.Unix())}, 0)
+
+	for key, counts := range countWindows(config, now, spots) {
+		labels := []string{strconv.Itoa(key.Country), key.Band, key.Mode, key.Direction, formatWindow(config.SpotsWindows[key.Window])}
+		metrics <- prometheus.MustNewConstMetric(windows.spots, prometheus.GaugeValue, float64(counts.Spots), labels...)
+		metrics <- prometheus.MustNewConstMetric(windows.stations, prometheus.GaugeValue, float64(counts.Stations), labels...)
+		if counts.Located {
+			metrics <- prometheus.MustNewConstMetric(windows.distances, prometheus.GaugeValue, float64(counts.Distance), labels...)
+		}
+	}
+}
+
+// Count the spots, in time order, into the windows that reach back to them
+// from now, shortest first in config.SpotsWindows. Going from the newest,
+// every spot and station goes into the shortest window it's in, and from there
+// into the longer ones. Groups get every window, the ones without spots at
+// zero, so that a quiet band reads as such rather than as missing.
+func countWindows(config Config, now time.Time, spots []*Payload) map[windowKey]windowCounts {
+	type group struct {
+		Country   int
+		Band      string
+		Mode      string
+		Direction string
+	}
+	type station struct {
+		Group    int
+		Callsign string
+	}
+	groups := make(map[group]int)
+	var shortest [][]windowCounts
+	seen := make(map[station]bool)
+
+	for i := len(spots) - 1; i >= 0; i-- {
+		spot := spots[i]
+		age := now.Sub(time.Unix(int64(spot.Time), 0))
+		first := 0
+		for first < len(config.SpotsWindows) && age > config.SpotsWindows[first] {
+			first += 1
+		}
+		if first == len(config.SpotsWindows) {
+			break
+		}
+
+		for _, classification := range Classify(config, spot) {
+			key := group{Country: classification.Country, Band: spot.Band, Mode: spot.Mode, Direction: classification.Direction}
+			id, ok := groups[key]
+			if !ok {
+				id = len(shortest)
+				groups[key] = id
+				shortest = append(shortest, make([]windowCounts, len(config.SpotsWindows)))
+			}
+
+			counts := &shortest[id][first]
+			counts.Spots += 1
+			if spot.located {
+				counts.Distance = max(counts.Distance, spot.Distance)
+				counts.Located = true
+			}
+			for _, callsign := range monitoredCallsigns(spot, classification.Direction) {
+				if !seen[station{id, callsign}] {
+					seen[station{id, callsign}] = true
+					counts.Stations += 1
+				}
+			}
+		}
+	}
+
+	windows := make(map[windowKey]windowCounts)
+	for key, id := range groups {
+		var total windowCounts
+		for window, counts := range shortest[id] {
+			total.Spots += counts.Spots
+			total.Stations += counts.Stations
+			if counts.Located {
+				total.Distance = max(total.Distance, counts.Distance)
+				total.Located = true
+			}
+			windows[windowKey{key.Country, key.Band, key.Mode, key.Direction, window}] = total
+		}
+	}
+
+	return windows
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCountWindows(t *testing.T) {
+	config := Config{Countries: []int{224}, SpotsWindows: []time.Duration{15 * time.Minute, time.Hour, 6 * time.Hour}}
+	now := time.Unix(1700000000, 0)
+	spot := func(minutesAgo int, sender string, receiver string, receiverCountry int, distance int64) *Payload {
+		return &Payload{
+			Time:             uint64(now.Add(-time.Duration(minutesAgo) * time.Minute).Unix()),
+			Band:             "2m",
+			Mode:             "FT8",
+			SenderCallsign:   sender,
+			SenderCountry:    224,
+			ReceiverCallsign: receiver,
+			ReceiverCountry:  receiverCountry,
+			Distance:         distance,
+			located:          distance != 0,
+		}
+	}
+	spots := []*Payload{
+		spot(400, "OH2OLD", "SM5ABC", 284, 3000),
+		spot(300, "OH2ABC", "SM5ABC", 284, 1200),
+		spot(50, "OH2ABC", "SM5ABC", 284, 400),
+		spot(10, "oh2abc", "SM6XYZ", 284, 800),
+		spot(5, "OH6XYZ", "SM6XYZ", 284, 0),
+		spot(5, "OH2ABC", "OH6XYZ", 224, 250),
+	}
+
+	sent := func(window int) windowKey {
+		return windowKey{Country: 224, Band: "2m", Mode: "FT8", Direction: DirectionSent, Window: window}
+	}
+	local := func(window int) windowKey {
+		return windowKey{Country: 224, Band: "2m", Mode: "FT8", Direction: DirectionLocal, Window: window}
+	}
+	tests := []struct {
+		key  windowKey
+		want windowCounts
+	}{
+		{sent(0), windowCounts{Spots: 2, Distance: 800, Located: true, Stations: 2}},
+		{sent(1), windowCounts{Spots: 3, Distance: 800, Located: true, Stations: 2}},
+		{sent(2), windowCounts{Spots: 4, Distance: 1200, Located: true, Stations: 2}},
+		{local(0), windowCounts{Spots: 1, Distance: 250, Located: true, Stations: 2}},
+		{local(2), windowCounts{Spots: 1, Distance: 250, Located: true, Stations: 2}},
+	}
+
+	windows := countWindows(config, now, spots)
+	if len(windows) != 6 {
+		t.Errorf("countWindows() = %d keys, want 6", len(windows))
+	}
+	if got, ok := windows[local(1)]; !ok || got != (windowCounts{Spots: 1, Distance: 250, Located: true, Stations: 2}) {
+		t.Errorf("countWindows()[%+v] = %+v, want the shorter window's counts", local(1), got)
+	}
+	for _, tt := range tests {
+		if got, ok := windows[tt.key]; !ok || got != tt.want {
+			t.Errorf("countWindows()[%+v] = %+v, want %+v", tt.key, got, tt.want)
+		}
+	}
+
+	// Only older spots, which leave the shorter windows at zero
+	windows = countWindows(config, now, []*Payload{spot(300, "OH2ABC", "SM5ABC", 284, 1200)})
+	for window, want := range []windowCounts{{}, {}, {Spots: 1, Distance: 1200, Located: true, Stations: 1}} {
+		if got, ok := windows[sent(window)]; !ok || got != want {
+			t.Errorf("countWindows()[%+v] = %+v, %v, want %+v", sent(window), got, ok, want)
+		}
+	}
+
+	// Nothing known about how far the spots reached
+	windows = countWindows(config, now, []*Payload{spot(5, "OH6XYZ", "SM6XYZ", 284, 0)})
+	if got := windows[sent(0)]; got.Located || got.Spots != 1 {
+		t.Errorf("countWindows()[%+v] = %+v, want one spot and no distance", sent(0), got)
+	}
+}